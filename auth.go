@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-sasl"
+)
+
+// Métodos de autenticação suportados.
+const (
+	AuthLogin         = "login"          // comando LOGIN com utilizador e senha
+	AuthPlain         = "plain"          // SASL PLAIN, com identidade de autorização se houver master_user
+	AuthDovecotMaster = "dovecot_master" // LOGIN "utilizador*master" com a senha do master (Dovecot)
+	AuthXOAuth2       = "xoauth2"        // SASL XOAUTH2 (Gmail, Microsoft 365)
+	AuthOAuthBearer   = "oauthbearer"    // SASL OAUTHBEARER (RFC 7628)
+)
+
+// tokenRefreshMargin antecipa a renovação do token para não o usar à beira de expirar.
+const tokenRefreshMargin = time.Minute
+
+// oauthTokenFile é o formato aceite para ficheiros de token em JSON.
+// Um ficheiro que não seja JSON é lido como um access token simples.
+type oauthTokenFile struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// oauthTokenResponse é a resposta do endpoint de tokens (RFC 6749, secção 5.1).
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+// oauthTokenSource fornece access tokens para um endpoint, renovando-os quando expiram.
+// É partilhado entre reconexões para que o token em cache sobreviva a cada novo dial.
+type oauthTokenSource struct {
+	mu           sync.Mutex
+	options      OAuthOptions
+	accessToken  string
+	refreshToken string
+	expiry       time.Time
+}
+
+// tokenSources guarda uma fonte de tokens por conjunto de opções OAuth, para que a verificação
+// de ligações, a migração e as reconexões usem o mesmo token e o mesmo refresh token, que
+// alguns fornecedores trocam a cada renovação.
+var tokenSources = struct {
+	mu      sync.Mutex
+	sources map[OAuthOptions]*oauthTokenSource
+}{sources: make(map[OAuthOptions]*oauthTokenSource)}
+
+// newOAuthTokenSource devolve a fonte de tokens das opções OAuth, criando-a na primeira vez.
+func newOAuthTokenSource(options OAuthOptions) *oauthTokenSource {
+	tokenSources.mu.Lock()
+	defer tokenSources.mu.Unlock()
+	if ts, ok := tokenSources.sources[options]; ok {
+		return ts
+	}
+	ts := &oauthTokenSource{
+		options:      options,
+		refreshToken: options.RefreshToken,
+	}
+	tokenSources.sources[options] = ts
+	return ts
+}
+
+// Token retorna um access token válido, lendo o ficheiro ou renovando-o se necessário.
+func (ts *oauthTokenSource) Token() (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.accessToken != "" && (ts.expiry.IsZero() || time.Now().Add(tokenRefreshMargin).Before(ts.expiry)) {
+		return ts.accessToken, nil
+	}
+
+	if ts.options.TokenFile != "" {
+		if err := ts.loadTokenFile(); err != nil {
+			return "", err
+		}
+		if ts.accessToken != "" && (ts.expiry.IsZero() || time.Now().Add(tokenRefreshMargin).Before(ts.expiry)) {
+			return ts.accessToken, nil
+		}
+	}
+
+	if ts.refreshToken == "" || ts.options.TokenURL == "" {
+		if ts.options.TokenFile == "" {
+			return "", fmt.Errorf("OAuth2 requer token_file ou refresh_token com token_url")
+		}
+		return "", fmt.Errorf("token OAuth2 em '%s' expirou e não há refresh_token/token_url para o renovar", ts.options.TokenFile)
+	}
+
+	if err := ts.refresh(); err != nil {
+		return "", err
+	}
+	return ts.accessToken, nil
+}
+
+// Expire descarta o token em cache, forçando a sua renovação no próximo pedido.
+func (ts *oauthTokenSource) Expire() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.accessToken = ""
+	ts.expiry = time.Time{}
+}
+
+// loadTokenFile lê o token do ficheiro configurado.
+func (ts *oauthTokenSource) loadTokenFile() error {
+	data, err := os.ReadFile(ts.options.TokenFile)
+	if err != nil {
+		return fmt.Errorf("erro ao ler ficheiro de token: %w", err)
+	}
+
+	var tf oauthTokenFile
+	if err := json.Unmarshal(data, &tf); err != nil {
+		// Não é JSON: o ficheiro contém apenas o access token
+		ts.accessToken = strings.TrimSpace(string(data))
+		ts.expiry = time.Time{}
+		if ts.accessToken == "" {
+			return fmt.Errorf("ficheiro de token '%s' está vazio", ts.options.TokenFile)
+		}
+		return nil
+	}
+
+	ts.accessToken = tf.AccessToken
+	ts.expiry = tf.Expiry
+	if tf.RefreshToken != "" {
+		ts.refreshToken = tf.RefreshToken
+	}
+	return nil
+}
+
+// refresh obtém um novo access token no endpoint de tokens usando o refresh token.
+func (ts *oauthTokenSource) refresh() error {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", ts.refreshToken)
+	if ts.options.ClientID != "" {
+		form.Set("client_id", ts.options.ClientID)
+	}
+	if ts.options.ClientSecret != "" {
+		form.Set("client_secret", ts.options.ClientSecret)
+	}
+	if ts.options.Scope != "" {
+		form.Set("scope", ts.options.Scope)
+	}
+
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	resp, err := httpClient.PostForm(ts.options.TokenURL, form)
+	if err != nil {
+		return fmt.Errorf("erro ao contactar endpoint de tokens: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tr oauthTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return fmt.Errorf("resposta inválida do endpoint de tokens (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || tr.AccessToken == "" {
+		return fmt.Errorf("endpoint de tokens recusou a renovação (HTTP %d): %s %s", resp.StatusCode, tr.Error, tr.Description)
+	}
+
+	ts.accessToken = tr.AccessToken
+	if tr.RefreshToken != "" {
+		ts.refreshToken = tr.RefreshToken
+	}
+	if tr.ExpiresIn > 0 {
+		ts.expiry = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	} else {
+		ts.expiry = time.Time{}
+	}
+
+	// O token já é válido; uma falha ao gravá-lo só obriga a renovar de novo na próxima execução
+	if ts.options.TokenFile != "" {
+		if err := ts.saveTokenFile(); err != nil {
+			log.Printf("AVISO: não foi possível gravar o token renovado em '%s': %v", ts.options.TokenFile, err)
+		}
+	}
+	return nil
+}
+
+// saveTokenFile grava o token atual e o refresh token (possivelmente trocado pelo endpoint)
+// no ficheiro de token, através de um ficheiro temporário.
+func (ts *oauthTokenSource) saveTokenFile() error {
+	data, err := json.MarshalIndent(oauthTokenFile{
+		AccessToken:  ts.accessToken,
+		RefreshToken: ts.refreshToken,
+		Expiry:       ts.expiry,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := ts.options.TokenFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ts.options.TokenFile)
+}
+
+// xoauth2Client implementa o mecanismo SASL XOAUTH2 usado pela Google e pela Microsoft.
+type xoauth2Client struct {
+	username string
+	token    string
+}
+
+func (a *xoauth2Client) Start() (mech string, ir []byte, err error) {
+	ir = []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01")
+	return "XOAUTH2", ir, nil
+}
+
+func (a *xoauth2Client) Next(challenge []byte) ([]byte, error) {
+	// Em caso de erro o servidor envia um JSON com detalhes e espera uma resposta vazia
+	return []byte{}, nil
+}
+
+// authenticate autentica a sessão IMAP com o método configurado no endpoint.
+func authenticate(c *imapclient.Client, ep imapEndpoint) error {
+	switch ep.Options.EffectiveAuth() {
+	case AuthXOAuth2, AuthOAuthBearer:
+		token, err := ep.Token.Token()
+		if err != nil {
+			return fmt.Errorf("falha ao obter token OAuth2: %w", err)
+		}
+
+		var saslClient sasl.Client
+		if ep.Options.EffectiveAuth() == AuthXOAuth2 {
+			saslClient = &xoauth2Client{username: ep.User, token: token}
+		} else {
+			saslClient = sasl.NewOAuthBearerClient(&sasl.OAuthBearerOptions{
+				Username: ep.User,
+				Token:    token,
+				Host:     ep.Host,
+				Port:     ep.Options.EffectivePort(),
+			})
+		}
+
+		if err := c.Authenticate(saslClient); err != nil {
+			return fmt.Errorf("falha na autenticação %s: %w", strings.ToUpper(ep.Options.EffectiveAuth()), err)
+		}
+		return nil
+	case AuthPlain:
+		// Com master_user, o administrador autentica-se e pede autorização como o utilizador
+		var saslClient sasl.Client
+		if ep.Options.MasterUser != "" {
+			saslClient = sasl.NewPlainClient(ep.User, ep.Options.MasterUser, ep.Options.MasterPass)
+		} else {
+			saslClient = sasl.NewPlainClient("", ep.User, ep.Pass)
+		}
+		if err := c.Authenticate(saslClient); err != nil {
+			if ep.Options.MasterUser != "" {
+				return fmt.Errorf("falha na autenticação PLAIN de %s como %s: %w", ep.Options.MasterUser, ep.User, err)
+			}
+			return fmt.Errorf("falha na autenticação PLAIN: %w", err)
+		}
+		return nil
+	case AuthDovecotMaster:
+		if ep.Options.MasterUser == "" {
+			return fmt.Errorf("autenticação dovecot_master requer master_user")
+		}
+		separator := ep.Options.MasterSeparator
+		if separator == "" {
+			separator = "*"
+		}
+		if err := c.Login(ep.User+separator+ep.Options.MasterUser, ep.Options.MasterPass).Wait(); err != nil {
+			return fmt.Errorf("falha ao fazer login de %s como master %s: %w", ep.User, ep.Options.MasterUser, err)
+		}
+		return nil
+	default:
+		if err := c.Login(ep.User, ep.Pass).Wait(); err != nil {
+			return fmt.Errorf("falha ao fazer login: %w", err)
+		}
+		return nil
+	}
+}
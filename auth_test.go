@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// A verificação de ligações e a migração usam a mesma fonte de tokens, e um refresh token
+// trocado pelo endpoint é usado na renovação seguinte e gravado no ficheiro de token.
+func TestOAuthTokenSourceKeepsRotatedRefreshToken(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, r.FormValue("refresh_token"))
+		n := len(received)
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "acesso" + string(rune('0'+n)),
+			"refresh_token": "renovacao" + string(rune('0'+n)),
+			"expires_in":    30, // abaixo de tokenRefreshMargin: renovado em cada pedido
+		})
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token.json")
+	options := ConnectionOptions{
+		Auth:  AuthXOAuth2,
+		OAuth: OAuthOptions{TokenFile: tokenFile, RefreshToken: "renovacao0", TokenURL: server.URL},
+	}
+	if err := os.WriteFile(tokenFile, []byte(`{"access_token": "expirado", "expiry": "2020-01-01T00:00:00Z"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	check := newEndpoint("imap.test", "user", "", options, nil)
+	migrate := newEndpoint("imap.test", "user", "", options, nil)
+	if check.Token != migrate.Token {
+		t.Fatal("endpoints com as mesmas opções têm fontes de tokens diferentes")
+	}
+
+	for i, ep := range []imapEndpoint{check, migrate} {
+		ep.Token.Expire()
+		if _, err := ep.Token.Token(); err != nil {
+			t.Fatalf("renovação %d: %v", i+1, err)
+		}
+	}
+	if want := []string{"renovacao0", "renovacao1"}; len(received) != 2 || received[0] != want[0] || received[1] != want[1] {
+		t.Errorf("refresh tokens enviados %v, esperado %v", received, want)
+	}
+
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved oauthTokenFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("ficheiro de token inválido %q: %v", data, err)
+	}
+	if saved.AccessToken != "acesso2" || saved.RefreshToken != "renovacao2" {
+		t.Errorf("ficheiro de token tem %+v, esperado acesso2/renovacao2", saved)
+	}
+}
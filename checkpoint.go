@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// checkpointRecord é uma linha do ficheiro de estado. O ficheiro é um log só de acréscimo:
+// cada linha altera o estado de uma pasta e a leitura reaplica-as por ordem.
+type checkpointRecord struct {
+	Type            string      `json:"t"`            // "folder", "uid", "flags" ou "mark" (ver apply)
+	Folder          string      `json:"f"`            // nome da pasta de origem
+	UIDValidity     uint32      `json:"v,omitempty"`  // UIDVALIDITY da pasta de origem
+	UID             imap.UID    `json:"u,omitempty"`  // UID de origem
+	DestUID         imap.UID    `json:"d,omitempty"`  // UID no destino (APPENDUID), se conhecido
+	DestUIDValidity uint32      `json:"dv,omitempty"` // UIDVALIDITY da pasta de destino
+	Flags           []imap.Flag `json:"fl,omitempty"` // flags aplicadas no destino
+	ModSeq          uint64      `json:"m,omitempty"`  // HIGHESTMODSEQ da origem já sincronizado
+}
+
+// migratedMessage guarda o que se sabe de uma mensagem de origem já copiada.
+type migratedMessage struct {
+	DestUID         imap.UID
+	DestUIDValidity uint32
+	Flags           []imap.Flag
+}
+
+// folderCheckpoint guarda o estado de uma pasta de origem.
+type folderCheckpoint struct {
+	UIDValidity   uint32
+	UIDs          map[imap.UID]*migratedMessage
+	HighestUID    imap.UID // todos os UIDs até aqui foram tratados
+	HighestModSeq uint64   // flags sincronizadas até este MODSEQ
+}
+
+// CheckpointStore regista, por conta e pasta, os UIDs de origem já copiados para o destino,
+// permitindo retomar uma migração interrompida sem voltar a copiar mensagens.
+type CheckpointStore struct {
+	mu       sync.Mutex
+	readOnly bool // dry-run: o estado é lido mas nada é escrito no disco
+	path     string
+	file     *os.File
+	folders  map[string]*folderCheckpoint
+}
+
+// safeFileName adapta um email para ser usado em nomes de ficheiros de estado.
+func safeFileName(s string) string {
+	s = strings.ReplaceAll(s, "@", "_at_")
+	s = strings.ReplaceAll(s, ".", "_")
+	s = strings.ReplaceAll(s, "/", "_")
+	return s
+}
+
+// checkpointFileName gera o nome do ficheiro de estado de uma conta.
+func checkpointFileName(sourceEmail, destEmail string) string {
+	return fmt.Sprintf("checkpoint_%s__%s.jsonl", safeFileName(sourceEmail), safeFileName(destEmail))
+}
+
+// OpenCheckpointStore abre (ou cria) o ficheiro de estado de uma conta no diretório indicado.
+// Em modo readOnly o estado existente é carregado e as alterações ficam apenas em memória.
+func OpenCheckpointStore(dir, sourceEmail, destEmail string, readOnly bool) (*CheckpointStore, error) {
+	store := &CheckpointStore{
+		readOnly: readOnly,
+		path:     filepath.Join(dir, checkpointFileName(sourceEmail, destEmail)),
+		folders:  make(map[string]*folderCheckpoint),
+	}
+
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	if readOnly {
+		return store, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("erro ao criar diretório de estado: %w", err)
+	}
+
+	file, err := os.OpenFile(store.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir ficheiro de estado: %w", err)
+	}
+	store.file = file
+	return store, nil
+}
+
+// load reaplica o log de estado existente. Uma última linha incompleta (processo morto a meio
+// de uma escrita) é ignorada.
+func (s *CheckpointStore) load() error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao ler ficheiro de estado: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec checkpointRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		s.apply(rec)
+	}
+	return scanner.Err()
+}
+
+// apply atualiza o estado em memória com um registo:
+//   - folder: início de uma pasta; uma UIDVALIDITY diferente descarta o estado anterior
+//   - uid:    mensagem copiada, com o UID e as flags no destino
+//   - flags:  flags de uma mensagem já copiada atualizadas no destino
+//   - mark:   marca de sincronização (maior UID tratado e HIGHESTMODSEQ)
+func (s *CheckpointStore) apply(rec checkpointRecord) {
+	fc := s.folders[rec.Folder]
+	switch rec.Type {
+	case "folder":
+		if fc == nil || fc.UIDValidity != rec.UIDValidity {
+			s.folders[rec.Folder] = &folderCheckpoint{
+				UIDValidity: rec.UIDValidity,
+				UIDs:        make(map[imap.UID]*migratedMessage),
+			}
+		}
+	case "uid":
+		if fc != nil {
+			fc.UIDs[rec.UID] = &migratedMessage{
+				DestUID:         rec.DestUID,
+				DestUIDValidity: rec.DestUIDValidity,
+				Flags:           rec.Flags,
+			}
+		}
+	case "flags":
+		if fc != nil && fc.UIDs[rec.UID] != nil {
+			fc.UIDs[rec.UID].Flags = rec.Flags
+		}
+	case "mark":
+		if fc != nil {
+			fc.HighestUID = rec.UID
+			if rec.ModSeq > 0 {
+				fc.HighestModSeq = rec.ModSeq
+			}
+		}
+	}
+}
+
+// write acrescenta um registo ao log e aplica-o em memória. Cada registo vai diretamente para
+// o ficheiro numa única escrita, para que um processo morto logo a seguir a um APPEND não perca
+// o registo; a sincronização com o disco (flush) fica para o fim de cada lote. Deve ser
+// chamado com o mutex.
+func (s *CheckpointStore) write(rec checkpointRecord) error {
+	if s.readOnly {
+		s.apply(rec)
+		return nil
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("erro ao escrever ficheiro de estado: %w", err)
+	}
+	s.apply(rec)
+	return nil
+}
+
+// BeginFolder regista a UIDVALIDITY atual de uma pasta de origem.
+// Retorna true se havia estado guardado com outra UIDVALIDITY, que é então descartado.
+func (s *CheckpointStore) BeginFolder(folder string, uidValidity uint32) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fc := s.folders[folder]
+	if fc != nil && fc.UIDValidity == uidValidity {
+		return false, nil
+	}
+	changed := fc != nil && len(fc.UIDs) > 0
+
+	if err := s.write(checkpointRecord{Type: "folder", Folder: folder, UIDValidity: uidValidity}); err != nil {
+		return false, err
+	}
+	return changed, s.flush()
+}
+
+// MigratedCount retorna quantas mensagens da pasta já estão registadas como migradas.
+func (s *CheckpointStore) MigratedCount(folder string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if fc := s.folders[folder]; fc != nil {
+		return len(fc.UIDs)
+	}
+	return 0
+}
+
+// IsMigrated verifica se um UID de origem já foi copiado numa execução anterior.
+func (s *CheckpointStore) IsMigrated(folder string, uid imap.UID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fc := s.folders[folder]
+	return fc != nil && fc.UIDs[uid] != nil
+}
+
+// Migrated retorna o registo de uma mensagem de origem já copiada.
+func (s *CheckpointStore) Migrated(folder string, uid imap.UID) (migratedMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fc := s.folders[folder]
+	if fc == nil || fc.UIDs[uid] == nil {
+		return migratedMessage{}, false
+	}
+	return *fc.UIDs[uid], true
+}
+
+// MarkMigrated regista um UID de origem como copiado, com o UID de destino se o servidor
+// o devolveu (APPENDUID) e as flags aplicadas.
+func (s *CheckpointStore) MarkMigrated(folder string, uid imap.UID, dest *imap.AppendData, flags []imap.Flag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.folders[folder] == nil {
+		return fmt.Errorf("pasta '%s' não foi iniciada no checkpoint", folder)
+	}
+	rec := checkpointRecord{Type: "uid", Folder: folder, UID: uid, Flags: flags}
+	if dest != nil {
+		rec.DestUID = dest.UID
+		rec.DestUIDValidity = dest.UIDValidity
+	}
+	return s.write(rec)
+}
+
+// DestinationMap devolve, para as mensagens copiadas para uma pasta de destino com a
+// UIDVALIDITY indicada, o mapa de UID de origem para UID de destino.
+func (s *CheckpointStore) DestinationMap(folder string, destUIDValidity uint32) map[imap.UID]imap.UID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mapping := make(map[imap.UID]imap.UID)
+	if fc := s.folders[folder]; fc != nil {
+		for uid, m := range fc.UIDs {
+			if m.DestUID != 0 && m.DestUIDValidity == destUIDValidity {
+				mapping[uid] = m.DestUID
+			}
+		}
+	}
+	return mapping
+}
+
+// UpdateFlags regista as novas flags de uma mensagem já copiada.
+func (s *CheckpointStore) UpdateFlags(folder string, uid imap.UID, flags []imap.Flag) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(checkpointRecord{Type: "flags", Folder: folder, UID: uid, Flags: flags})
+}
+
+// HighWaterMark retorna o maior UID tratado e o HIGHESTMODSEQ sincronizado de uma pasta.
+func (s *CheckpointStore) HighWaterMark(folder string) (imap.UID, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if fc := s.folders[folder]; fc != nil {
+		return fc.HighestUID, fc.HighestModSeq
+	}
+	return 0, 0
+}
+
+// SetHighWaterMark regista a marca de sincronização de uma pasta. Um modSeq 0 mantém o anterior.
+func (s *CheckpointStore) SetHighWaterMark(folder string, uid imap.UID, modSeq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.folders[folder] == nil {
+		return fmt.Errorf("pasta '%s' não foi iniciada no checkpoint", folder)
+	}
+	if err := s.write(checkpointRecord{Type: "mark", Folder: folder, UID: uid, ModSeq: modSeq}); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+// flush sincroniza o ficheiro com o disco. Deve ser chamado com o mutex.
+func (s *CheckpointStore) flush() error {
+	if s.readOnly {
+		return nil
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("erro ao sincronizar ficheiro de estado: %w", err)
+	}
+	return nil
+}
+
+// Sync garante que os registos já escritos estão no disco.
+func (s *CheckpointStore) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+// Close sincroniza e fecha o ficheiro de estado.
+func (s *CheckpointStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.readOnly {
+		return nil
+	}
+	flushErr := s.flush()
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
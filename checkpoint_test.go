@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// Cada mensagem registada tem de estar no ficheiro logo a seguir ao MarkMigrated, sem esperar
+// pelo Sync do fim do lote.
+func TestMarkMigratedWritesImmediately(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenCheckpointStore(dir, "origem@test", "destino@test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.BeginFolder("INBOX", 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.MarkMigrated("INBOX", 42, &imap.AppendData{UID: 3, UIDValidity: 9}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"u":42`) {
+		t.Fatalf("registo do UID 42 não está no ficheiro: %q", data)
+	}
+
+	reopened, err := OpenCheckpointStore(dir, "origem@test", "destino@test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := reopened.Migrated("INBOX", 42); !ok || m.DestUID != 3 || m.DestUIDValidity != 9 {
+		t.Errorf("Migrated(INBOX, 42) = %+v, %v", m, ok)
+	}
+}
+
+// Numa execução retomada em que todas as mensagens já estão no checkpoint, a pasta do destino
+// não é aberta: nem SELECT nem EXAMINE para o índice de duplicados.
+func TestResumedRunSkipsDestinationWhenNothingLeft(t *testing.T) {
+	chdirTemp(t)
+
+	source := newTestServer(t, nil, nil)
+	dest := newTestServer(t, nil, nil)
+	source.addMessage(t, "INBOX", testMessage("<1@test>", "um"))
+	source.addMessage(t, "INBOX", testMessage("<2@test>", "dois"))
+
+	acc := MigrationAccount{
+		SourceEmail: "origem@test", SourceUser: "user", SourcePass: "pass", SourceHost: source.Host,
+		DestinationEmail: "destino@test", DestinationUser: "user", DestinationPass: "pass", DestinationHost: dest.Host,
+		SourceConnection: source.options(), DestinationConnection: dest.options(),
+	}
+	config := loadTestConfig(t, fmt.Sprintf(`{"skip_duplicates": true, "state_dir": %q}`, filepath.Join(t.TempDir(), "state")))
+	group := groupAccountsByDestination([]MigrationAccount{acc}, config)[0]
+	if err := migrateAccount(acc, config, group); err != nil {
+		t.Fatal(err)
+	}
+
+	before := len(dest.Commands())
+	if err := migrateAccount(acc, config, group); err != nil {
+		t.Fatal(err)
+	}
+	for _, command := range dest.Commands()[before:] {
+		if command == "SELECT" || command == "EXAMINE" {
+			t.Errorf("o destino recebeu %s na execução retomada; comandos: %v", command, dest.Commands()[before:])
+		}
+	}
+}
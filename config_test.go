@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadConfigStateDir(t *testing.T) {
+	tests := []struct {
+		config string
+		want   string
+	}{
+		{config: `{}`, want: "state"},
+		{config: `{"state_dir": ""}`, want: ""},
+		{config: `{"state_dir": "/var/lib/migrator"}`, want: "/var/lib/migrator"},
+	}
+	for _, tt := range tests {
+		if got := loadTestConfig(t, tt.config).StateDir; got != tt.want {
+			t.Errorf("LoadConfig(%s).StateDir = %q, esperado %q", tt.config, got, tt.want)
+		}
+	}
+}
+
+func TestMergeConnectionOptionsPort(t *testing.T) {
+	tests := []struct {
+		name           string
+		base, override ConnectionOptions
+		want           int
+	}{
+		{name: "sem alterações", base: ConnectionOptions{Port: 993, Security: SecurityTLS}, want: 993},
+		{name: "starttls na linha", base: ConnectionOptions{Port: 993, Security: SecurityTLS}, override: ConnectionOptions{Security: SecurityStartTLS}, want: 143},
+		{name: "tls na linha", base: ConnectionOptions{Port: 143, Security: SecurityStartTLS}, override: ConnectionOptions{Security: SecurityTLS}, want: 993},
+		{name: "porta global própria", base: ConnectionOptions{Port: 10143, Security: SecurityTLS}, override: ConnectionOptions{Security: SecurityStartTLS}, want: 10143},
+		{name: "porta na linha", base: ConnectionOptions{Port: 993, Security: SecurityTLS}, override: ConnectionOptions{Port: 1143, Security: SecurityStartTLS}, want: 1143},
+		{name: "sem porta global", base: ConnectionOptions{}, override: ConnectionOptions{Security: SecurityPlain}, want: 143},
+	}
+	for _, tt := range tests {
+		if got := mergeConnectionOptions(tt.base, tt.override).EffectivePort(); got != tt.want {
+			t.Errorf("%s: porta %d, esperado %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigMasterCredentials(t *testing.T) {
+	tests := []struct {
+		connection string
+		wantErr    bool
+	}{
+		{connection: `{"auth": "dovecot_master", "master_user": "admin", "master_pass": "segredo"}`},
+		{connection: `{"auth": "plain", "master_user": "admin", "master_pass": "segredo"}`},
+		{connection: `{"auth": "plain"}`},
+		{connection: `{"auth": "dovecot_master"}`, wantErr: true},
+		{connection: `{"auth": "plain", "master_user": "admin"}`, wantErr: true},
+		{connection: `{"auth": "login", "master_pass": "segredo"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		for _, side := range []string{"source_connection", "destination_connection"} {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(`{"`+side+`": `+tt.connection+`}`), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadConfig(path); (err != nil) != tt.wantErr {
+				t.Errorf("%s %s: erro = %v, esperado erro = %v", side, tt.connection, err, tt.wantErr)
+			}
+		}
+	}
+}
+
+// As credenciais de administrador de uma linha do CSV são validadas em conjunto com as da
+// configuração: a linha pode pedir dovecot_master e usar o master_user global.
+func TestReadCSVMasterCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.csv")
+	csv := "source_email,source_user,source_pass,source_host,dest_email,dest_user,dest_pass,dest_host,autenticacao_origem,master_origem\n" +
+		"a@test,a,,origem,a@test,a,p,destino,dovecot_master,\n" +
+		"b@test,b,,origem,b@test,b,p,destino,dovecot_master,outro\n" +
+		"c@test,c,p,origem,c@test,c,p,destino,,\n"
+	if err := os.WriteFile(path, []byte(csv), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		source ConnectionOptions
+		want   []string
+	}{
+		{name: "sem master global", want: []string{"c@test"}},
+		{name: "master global", source: ConnectionOptions{MasterUser: "admin", MasterPass: "segredo"}, want: []string{"a@test", "b@test", "c@test"}},
+	}
+	for _, tt := range tests {
+		accounts, err := readCSV(path, MigrationConfig{SourceConnection: tt.source})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, acc := range accounts {
+			got = append(got, acc.SourceEmail)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: contas %v, esperado %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// imapEndpoint reúne os dados necessários para abrir uma sessão IMAP num servidor.
+type imapEndpoint struct {
+	Host     string
+	User     string
+	Pass     string
+	Options  ConnectionOptions
+	Token    *oauthTokenSource // apenas para autenticação OAuth2
+	Proxy    *url.URL          // nil = ligação direta
+	ReadOnly bool              // bloquear comandos que alterem a caixa de correio (origem)
+}
+
+// newEndpoint cria um endpoint com a fonte de tokens das suas opções OAuth2, partilhada por
+// todos os endpoints com as mesmas opções.
+func newEndpoint(host, user, pass string, options ConnectionOptions, proxy *url.URL) imapEndpoint {
+	ep := imapEndpoint{
+		Host:    host,
+		User:    user,
+		Pass:    pass,
+		Options: options,
+		Proxy:   proxy,
+	}
+	if options.UsesOAuth() {
+		ep.Token = newOAuthTokenSource(options.OAuth)
+	}
+	return ep
+}
+
+// Address devolve o endereço host:porta do servidor.
+func (ep imapEndpoint) Address() string {
+	return net.JoinHostPort(ep.Host, strconv.Itoa(ep.Options.EffectivePort()))
+}
+
+// proxyURL devolve o proxy da conta, ou o proxy global se a linha não definir nenhum.
+func (acc MigrationAccount) proxyURL(config MigrationConfig) *url.URL {
+	raw := config.Proxy
+	if acc.Proxy != "" {
+		raw = acc.Proxy
+	}
+	// Os valores já foram validados em LoadConfig e readCSV
+	u, _ := parseProxyURL(raw)
+	return u
+}
+
+// sourceEndpoint devolve o endpoint de origem de uma conta, combinando o CSV com a configuração.
+// A ligação à origem é protegida para que nenhum comando a possa alterar.
+func (acc MigrationAccount) sourceEndpoint(config MigrationConfig) imapEndpoint {
+	ep := newEndpoint(acc.SourceHost, acc.SourceUser, acc.SourcePass,
+		mergeConnectionOptions(config.SourceConnection, acc.SourceConnection), acc.proxyURL(config))
+	ep.ReadOnly = true
+	return ep
+}
+
+// destinationEndpoint devolve o endpoint de destino de uma conta, combinando o CSV com a configuração.
+func (acc MigrationAccount) destinationEndpoint(config MigrationConfig) imapEndpoint {
+	return newEndpoint(acc.DestinationHost, acc.DestinationUser, acc.DestinationPass,
+		mergeConnectionOptions(config.DestinationConnection, acc.DestinationConnection), acc.proxyURL(config))
+}
+
+// tlsInfo regista o resultado do handshake TLS de uma ligação.
+type tlsInfo struct {
+	Version uint16
+	Subject string
+}
+
+// String descreve a versão TLS negociada e o titular do certificado.
+func (t *tlsInfo) String() string {
+	if t == nil || t.Version == 0 {
+		return "sem TLS"
+	}
+	return fmt.Sprintf("%s, %s", tls.VersionName(t.Version), t.Subject)
+}
+
+// buildTLSConfig cria a configuração TLS do endpoint e regista o handshake em info.
+func buildTLSConfig(ep imapEndpoint, info *tlsInfo) (*tls.Config, error) {
+	opts := ep.Options.TLS
+	cfg := &tls.Config{
+		ServerName:         ep.Host,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+
+	if opts.MinVersion != "" {
+		cfg.MinVersion = tlsVersions[opts.MinVersion]
+	}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler ca_file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca_file '%s' não contém certificados PEM válidos", opts.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if opts.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao carregar certificado de cliente: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	pins := make(map[string]bool)
+	for _, pin := range opts.PinSHA256 {
+		pins[strings.ToLower(strings.ReplaceAll(pin, ":", ""))] = true
+	}
+
+	// Chamado depois da verificação normal (ou em vez dela, com insecure_skip_verify)
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("servidor não apresentou certificado")
+		}
+		leaf := cs.PeerCertificates[0]
+		info.Version = cs.Version
+		info.Subject = leaf.Subject.String()
+
+		if len(pins) > 0 {
+			sum := sha256.Sum256(leaf.Raw)
+			fingerprint := hex.EncodeToString(sum[:])
+			if !pins[fingerprint] {
+				return fmt.Errorf("certificado do servidor (SHA-256 %s) não corresponde a nenhum pin_sha256", fingerprint)
+			}
+		}
+		return nil
+	}
+
+	return cfg, nil
+}
+
+// dialTCP abre a ligação TCP ao servidor, diretamente ou através do proxy configurado.
+func dialTCP(ep imapEndpoint) (net.Conn, error) {
+	if ep.Proxy != nil {
+		return dialThroughProxy(ep.Proxy, ep.Address())
+	}
+	return net.DialTimeout("tcp", ep.Address(), 30*time.Second)
+}
+
+// dialEndpoint abre a ligação ao servidor de acordo com o modo de segurança configurado.
+func dialEndpoint(ep imapEndpoint) (*imapclient.Client, *tlsInfo, error) {
+	addr := ep.Address()
+	info := &tlsInfo{}
+
+	security := ep.Options.EffectiveSecurity()
+	var options *imapclient.Options
+	if security != SecurityPlain {
+		tlsConfig, err := buildTLSConfig(ep, info)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ep.Options.TLS.InsecureSkipVerify {
+			log.Printf("AVISO: verificação do certificado de %s desativada (insecure_skip_verify)", addr)
+		}
+		options = &imapclient.Options{TLSConfig: tlsConfig}
+	}
+
+	conn, err := dialTCP(ep)
+	if err != nil {
+		var proxyErr *ProxyError
+		if errors.As(err, &proxyErr) {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("falha ao conectar a %s: %w", addr, err)
+	}
+
+	// O STARTTLS é negociado aqui e não pelo imapclient para que a proteção de só leitura
+	// possa inspecionar os comandos já em claro, acima da camada TLS.
+	switch security {
+	case SecurityStartTLS:
+		tlsConn, err := negotiateStartTLS(conn, options.TLSConfig)
+		if err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("falha ao conectar via STARTTLS: %w", err)
+		}
+		conn = tlsConn
+	case SecurityPlain:
+		info = nil
+	default:
+		options.TLSConfig.NextProtos = []string{"imap"}
+		tlsConn := tls.Client(conn, options.TLSConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("falha ao conectar via TLS: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	if ep.ReadOnly {
+		conn = newReadOnlyConn(conn)
+	}
+	return imapclient.New(conn, options), info, nil
+}
+
+// openSession liga-se ao servidor e autentica-se, devolvendo também os dados do handshake TLS.
+func openSession(ep imapEndpoint) (*imapclient.Client, *tlsInfo, error) {
+	c, info, err := dialEndpoint(ep)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := authenticate(c, ep); err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+
+	// UTF8=ACCEPT não é ativado: depois do ENABLE a go-imap continua a descodificar os nomes
+	// das pastas como UTF-7 modificado e um LIST com "R&D" falha. Os nomes seguem sempre em
+	// UTF-7 modificado, que a biblioteca codifica e descodifica.
+
+	return c, info, nil
+}
+
+// connectClient estabelece conexão com um servidor IMAP e autentica-se.
+func connectClient(ep imapEndpoint) (*imapclient.Client, error) {
+	c, _, err := openSession(ep)
+	return c, err
+}
+
+// testConnection testa a conexão com um servidor IMAP e devolve os dados do handshake TLS.
+func testConnection(ep imapEndpoint) (*tlsInfo, error) {
+	client, info, err := openSession(ep)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Logout()
+	return info, nil
+}
+
+// isConnectionClosed verifica se um erro indica conexão fechada.
+func isConnectionClosed(err error) bool {
+	if err == nil {
+		return false
+	}
+	errStr := err.Error()
+	return strings.Contains(errStr, "closed network connection") ||
+		strings.Contains(errStr, "EOF") ||
+		strings.Contains(errStr, "broken pipe") ||
+		strings.Contains(errStr, "connection reset")
+}
+
+// reconnectIfNeeded reconecta se o erro indicar conexão fechada.
+func reconnectIfNeeded(client **imapclient.Client, ep imapEndpoint, err error) error {
+	if !isConnectionClosed(err) {
+		return err
+	}
+
+	log.Printf("Conexão fechada detectada. Tentando reconectar a %s...", ep.Address())
+
+	if *client != nil {
+		(*client).Close()
+	}
+
+	// Sessões longas podem ter sido fechadas por expiração do token
+	if ep.Token != nil {
+		ep.Token.Expire()
+	}
+
+	newClient, err := connectClient(ep)
+	if err != nil {
+		return fmt.Errorf("falha ao reconectar: %w", err)
+	}
+
+	*client = newClient
+	log.Printf("Reconexão bem-sucedida a %s", ep.Address())
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// serveUTF8Accept responde a uma ligação como o Gmail: anuncia UTF8=ACCEPT e, depois do
+// ENABLE, envia os nomes das pastas em UTF-8 sem escapar o '&'.
+func serveUTF8Accept(conn net.Conn, folder string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* OK [CAPABILITY IMAP4rev1 UTF8=ACCEPT] pronto\r\n")
+
+	utf8 := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		tag, command := fields[0], strings.ToUpper(fields[1])
+		switch command {
+		case "CAPABILITY":
+			fmt.Fprint(conn, "* CAPABILITY IMAP4rev1 UTF8=ACCEPT\r\n")
+		case "ENABLE":
+			utf8 = true
+			fmt.Fprint(conn, "* ENABLED UTF8=ACCEPT\r\n")
+		case "LIST":
+			name := folder
+			if !utf8 {
+				name = strings.ReplaceAll(name, "&", "&-")
+			}
+			fmt.Fprintf(conn, "* LIST () \"/\" INBOX\r\n* LIST () \"/\" %q\r\n", name)
+		case "LOGOUT":
+			fmt.Fprintf(conn, "* BYE\r\n%s OK LOGOUT\r\n", tag)
+			return
+		}
+		fmt.Fprintf(conn, "%s OK %s\r\n", tag, command)
+	}
+}
+
+// Um servidor que anuncia UTF8=ACCEPT tem de continuar a listar pastas com '&'.
+func TestOpenSessionListsAmpersandFolderWithUTF8Accept(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveUTF8Accept(conn, "R&D")
+		}
+	}()
+
+	opts := ConnectionOptions{Port: ln.Addr().(*net.TCPAddr).Port, Security: SecurityPlain}
+	client, err := connectClient(newEndpoint("127.0.0.1", "user", "pass", opts, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	mailboxes, err := client.List("", "*", nil).Collect()
+	if err != nil {
+		t.Fatalf("LIST: %v", err)
+	}
+	var names []string
+	for _, mb := range mailboxes {
+		names = append(names, mb.Mailbox)
+	}
+	if len(names) != 2 || names[1] != "R&D" {
+		t.Errorf("LIST = %q, esperado [INBOX R&D]", names)
+	}
+}
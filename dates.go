@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Origens possíveis da data de uma mensagem, usada no APPEND e nos filtros date_from/date_to.
+const (
+	DateSourceInternal = "internaldate"    // INTERNALDATE da origem (padrão)
+	DateSourceHeader   = "header"          // cabeçalho Date:
+	DateSourceReceived = "received-header" // data do Received: mais recente
+	DateSourceImport   = "import"          // hora da importação, atribuída pelo destino
+)
+
+// MessageDate retorna a data de uma mensagem segundo a origem configurada. Se a origem
+// escolhida não tiver data válida, recorre ao INTERNALDATE. Com "import" devolve zero,
+// para que o destino use a hora do APPEND.
+func (m messageMeta) MessageDate(source string) time.Time {
+	var date time.Time
+	switch source {
+	case DateSourceImport:
+		return time.Time{}
+	case DateSourceHeader:
+		date = m.EnvelopeDate()
+	case DateSourceReceived:
+		date = m.ReceivedDate
+	}
+	if !validMessageDate(date) {
+		date = m.InternalDate
+	}
+	return date
+}
+
+// FilterDate retorna a data usada pelos filtros date_from/date_to. Com "import" todas as
+// mensagens ficariam com a data de hoje, por isso os filtros usam o INTERNALDATE.
+func (m messageMeta) FilterDate(source string) time.Time {
+	if source == DateSourceImport {
+		return m.InternalDate
+	}
+	return m.MessageDate(source)
+}
+
+// validMessageDate rejeita datas ausentes ou claramente erradas (antes de 1980 ou no futuro).
+func validMessageDate(t time.Time) bool {
+	return !t.IsZero() && t.Year() >= 1980 && t.Before(time.Now().Add(24*time.Hour))
+}
+
+// parseReceivedDate extrai a data do primeiro cabeçalho Received: (o do último servidor que
+// entregou a mensagem), que vem depois do último ';' do cabeçalho.
+func parseReceivedDate(header []byte) time.Time {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(header)))
+	fields, _ := r.ReadMIMEHeader()
+	received := fields.Get("Received")
+	i := strings.LastIndex(received, ";")
+	if i < 0 {
+		return time.Time{}
+	}
+	date, err := mail.ParseDate(strings.TrimSpace(received[i+1:]))
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// dedupIndex é o índice de duplicados de uma pasta do destino guardado no disco. Só é válido
+// para a UIDVALIDITY e a chave com que foi construído; nas execuções seguintes apenas os UIDs
+// a partir de UIDNext são obtidos do servidor.
+type dedupIndex struct {
+	UIDValidity uint32              `json:"uidvalidity"`
+	UIDNext     imap.UID            `json:"uidnext"`
+	Key         string              `json:"key"`      // DuplicateKey* usado para as chaves
+	Messages    map[imap.UID]string `json:"messages"` // UID no destino -> Message-ID ou impressão digital
+}
+
+// dedupIndexDir devolve o diretório dos índices de duplicados de uma conta de destino,
+// identificada como nos grupos de destino (servidor:porta|utilizador, ver destinationKey).
+func dedupIndexDir(stateDir, destKey string) string {
+	name := strings.NewReplacer(":", "_", "|", "__").Replace(destKey)
+	return filepath.Join(stateDir, "dedup_"+safeFileName(name))
+}
+
+// dedupIndexPath devolve o ficheiro do índice de uma pasta do destino.
+func dedupIndexPath(dir, folderName string) string {
+	return filepath.Join(dir, url.PathEscape(folderName)+".json")
+}
+
+// loadDedupIndex lê o índice de uma pasta. Um índice inexistente ou ilegível é tratado como
+// vazio e reconstruído.
+func loadDedupIndex(dir, folderName string) *dedupIndex {
+	index := &dedupIndex{}
+	if dir != "" {
+		if data, err := os.ReadFile(dedupIndexPath(dir, folderName)); err == nil {
+			if json.Unmarshal(data, index) != nil {
+				index = &dedupIndex{}
+			}
+		}
+	}
+	if index.Messages == nil {
+		index.Messages = make(map[imap.UID]string)
+	}
+	return index
+}
+
+// save grava o índice num ficheiro temporário e renomeia-o, para não deixar um índice
+// incompleto se o processo for interrompido.
+func (idx *dedupIndex) save(dir, folderName string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("erro ao criar diretório do índice de duplicados: %w", err)
+	}
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	path := dedupIndexPath(dir, folderName)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("erro ao gravar índice de duplicados: %w", err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// update traz o índice para o estado atual da pasta selecionada: descarta-o se a UIDVALIDITY
+// ou a chave mudaram e obtém as chaves dos UIDs novos. O índice guarda todos os UIDs, mesmo
+// os sem chave, para que o número de entradas possa ser comparado com o da pasta; se não
+// coincidir, reconcilia-o com UID SEARCH ALL. Devolve quantas mensagens foram obtidas.
+func (idx *dedupIndex) update(client *imapclient.Client, data *imap.SelectData, keyMode string) (int, error) {
+	if idx.UIDValidity != data.UIDValidity || idx.Key != keyMode {
+		*idx = dedupIndex{UIDValidity: data.UIDValidity, Key: keyMode, Messages: make(map[imap.UID]string)}
+	}
+
+	fetched := 0
+	from := idx.UIDNext
+	if from == 0 {
+		from = 1
+	}
+	if data.NumMessages > 0 && (data.UIDNext == 0 || from < data.UIDNext) {
+		uidSet := imap.UIDSet{}
+		uidSet.AddRange(from, 0)
+		keys, err := fetchDedupKeys(client, uidSet, keyMode)
+		if err != nil {
+			return 0, err
+		}
+		for uid, key := range keys {
+			// "n:*" devolve sempre a última mensagem, mesmo com UID < n
+			if uid >= from {
+				idx.Messages[uid] = key
+				fetched++
+			}
+		}
+	}
+	idx.UIDNext = data.UIDNext
+
+	// Mensagens apagadas no destino deixariam de ser duplicados; um índice antigo pode também
+	// não ter os UIDs sem chave
+	if len(idx.Messages) != int(data.NumMessages) {
+		n, err := idx.reconcile(client, keyMode)
+		fetched += n
+		if err != nil {
+			return fetched, err
+		}
+	}
+	return fetched, nil
+}
+
+// reconcile retira do índice os UIDs que já não existem na pasta e obtém as chaves dos que
+// existem mas lhe faltam. Devolve quantas mensagens foram obtidas.
+func (idx *dedupIndex) reconcile(client *imapclient.Client, keyMode string) (int, error) {
+	searchData, err := client.UIDSearch(&imap.SearchCriteria{}, nil).Wait()
+	if err != nil {
+		return 0, err
+	}
+	existing := make(map[imap.UID]bool)
+	missing := imap.UIDSet{}
+	for _, uid := range searchData.AllUIDs() {
+		existing[uid] = true
+		if _, ok := idx.Messages[uid]; !ok {
+			missing.AddNum(uid)
+		}
+	}
+	for uid := range idx.Messages {
+		if !existing[uid] {
+			delete(idx.Messages, uid)
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+
+	keys, err := fetchDedupKeys(client, missing, keyMode)
+	if err != nil {
+		return 0, err
+	}
+	for uid, key := range keys {
+		idx.Messages[uid] = key
+	}
+	return len(keys), nil
+}
+
+// fetchDedupKeys obtém a chave de duplicados de cada mensagem de uidSet: o Message-ID do
+// envelope ou, com chave por conteúdo, a impressão digital calculada em streaming. As
+// mensagens sem Message-ID ficam com a chave vazia.
+func fetchDedupKeys(client *imapclient.Client, uidSet imap.UIDSet, keyMode string) (map[imap.UID]string, error) {
+	content := keyMode == DuplicateKeyContent || keyMode == DuplicateKeyRaw
+	fetchOptions := &imap.FetchOptions{UID: true, Envelope: !content}
+	if content {
+		fetchOptions.BodySection = []*imap.FetchItemBodySection{{Peek: true}}
+	}
+
+	keys := make(map[imap.UID]string)
+	cmd := client.Fetch(uidSet, fetchOptions)
+	for {
+		msg := cmd.Next()
+		if msg == nil {
+			break
+		}
+		var uid imap.UID
+		var key string
+		for {
+			item := msg.Next()
+			if item == nil {
+				break
+			}
+			switch item := item.(type) {
+			case imapclient.FetchItemDataUID:
+				uid = item.UID
+			case imapclient.FetchItemDataEnvelope:
+				if item.Envelope != nil {
+					key = item.Envelope.MessageID
+				}
+			case imapclient.FetchItemDataBodySection:
+				if item.Literal == nil {
+					continue
+				}
+				fingerprint, err := contentFingerprint(item.Literal, keyMode)
+				if err != nil {
+					cmd.Close()
+					return nil, err
+				}
+				key = fingerprint
+			}
+		}
+		if uid != 0 {
+			keys[uid] = key
+		}
+	}
+	if err := cmd.Close(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// Uma mensagem apagada no destino deixa de contar como duplicado na execução seguinte, mesmo
+// com mensagens sem Message-ID na pasta ou com outra mensagem acrescentada depois.
+func TestDedupIndexDropsExpungedMessages(t *testing.T) {
+	tests := []struct {
+		name        string
+		appendAfter bool // acrescentar outra mensagem depois de apagar
+	}{
+		{name: "mensagem sem Message-ID"},
+		{name: "apagar e acrescentar", appendAfter: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := newTestServer(t, nil, nil)
+			dest.addMessage(t, "INBOX", "From: a@test\r\nSubject: sem id\r\n\r\nsem id\r\n")
+			dest.addMessage(t, "INBOX", testMessage("<a@test>", "a"))
+			indexDir := filepath.Join(t.TempDir(), "dedup")
+
+			client, err := connectClient(newEndpoint(dest.Host, "user", "pass", dest.options(), nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Logout()
+
+			dt := NewDuplicateTracker(DuplicateScopeFolder, DuplicateKeyMessageID, indexDir, 0)
+			if _, err := dt.BuildExistingMessagesIndex(client, "origem@test", "INBOX", nil); err != nil {
+				t.Fatal(err)
+			}
+			if _, dup := dt.IsDuplicate("origem@test", "INBOX", "a@test"); !dup {
+				t.Fatal("a@test não está no índice")
+			}
+
+			if _, err := client.Select("INBOX", nil).Wait(); err != nil {
+				t.Fatal(err)
+			}
+			store := &imap.StoreFlags{Op: imap.StoreFlagsAdd, Silent: true, Flags: []imap.Flag{imap.FlagDeleted}}
+			if err := client.Store(imap.UIDSetNum(2), store, nil).Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.Expunge().Close(); err != nil {
+				t.Fatal(err)
+			}
+			if tt.appendAfter {
+				dest.addMessage(t, "INBOX", testMessage("<b@test>", "b"))
+			}
+
+			dt = NewDuplicateTracker(DuplicateScopeFolder, DuplicateKeyMessageID, indexDir, 0)
+			if _, err := dt.BuildExistingMessagesIndex(client, "origem@test", "INBOX", nil); err != nil {
+				t.Fatal(err)
+			}
+			if _, dup := dt.IsDuplicate("origem@test", "INBOX", "a@test"); dup {
+				t.Errorf("a@test continua duplicado depois de apagado; índice: %v", loadDedupIndex(indexDir, "INBOX").Messages)
+			}
+			if _, dup := dt.IsDuplicate("origem@test", "INBOX", "b@test"); dup != tt.appendAfter {
+				t.Errorf("b@test duplicado = %v, esperado %v", dup, tt.appendAfter)
+			}
+		})
+	}
+}
+
+// Um índice gravado sem os UIDs das mensagens sem chave é completado e limpo.
+func TestDedupIndexReconcilesOldIndex(t *testing.T) {
+	dest := newTestServer(t, nil, nil)
+	dest.addMessage(t, "INBOX", "From: a@test\r\nSubject: sem id\r\n\r\nsem id\r\n")
+	dest.addMessage(t, "INBOX", testMessage("<a@test>", "a"))
+
+	client, err := connectClient(newEndpoint(dest.Host, "user", "pass", dest.options(), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Logout()
+	data, err := client.Select("INBOX", &imap.SelectOptions{ReadOnly: true}).Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := &dedupIndex{
+		UIDValidity: data.UIDValidity,
+		UIDNext:     data.UIDNext,
+		Key:         DuplicateKeyMessageID,
+		Messages:    map[imap.UID]string{2: "a@test", 7: "apagada@test"},
+	}
+	if _, err := old.update(client, data, DuplicateKeyMessageID); err != nil {
+		t.Fatal(err)
+	}
+	want := map[imap.UID]string{1: "", 2: "a@test"}
+	if len(old.Messages) != len(want) || old.Messages[1] != "" || old.Messages[2] != "a@test" {
+		t.Errorf("índice %v, esperado %v", old.Messages, want)
+	}
+}
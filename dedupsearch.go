@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// Estratégias para saber que mensagens já existem numa pasta do destino.
+const (
+	duplicateStrategyIndex  = "index"  // obter o Message-ID (ou o conteúdo) de todas as mensagens
+	duplicateStrategySearch = "search" // UID SEARCH HEADER Message-ID só para os candidatos
+)
+
+// duplicateSearchBatch é o número de Message-IDs combinados com OR num único SEARCH.
+const duplicateSearchBatch = 50
+
+// duplicateIndexStats resume a preparação da detecção de duplicados de uma pasta.
+type duplicateIndexStats struct {
+	Strategy string
+	Indexed  int // mensagens no índice
+	Fetched  int // mensagens obtidas do servidor para o índice
+	Probed   int // Message-IDs procurados com SEARCH
+	Found    int // Message-IDs encontrados no destino
+}
+
+// String descreve a preparação para os logs.
+func (s duplicateIndexStats) String() string {
+	if s.Strategy == duplicateStrategySearch {
+		return fmt.Sprintf("procura com SEARCH de %d Message-IDs, %d já existem", s.Probed, s.Found)
+	}
+	return fmt.Sprintf("índice de %d mensagens (%d obtidas do servidor)", s.Indexed, s.Fetched)
+}
+
+// chooseStrategy decide entre indexar a pasta e procurar os candidatos com SEARCH. A procura
+// só é usada com chave message-id (o conteúdo não se procura no servidor), em pastas com
+// pelo menos searchThreshold mensagens, e quando os candidatos são menos de um décimo das
+// mensagens que a indexação teria de obter (todas, ou só as novas se houver índice no disco).
+func (dt *DuplicateTracker) chooseStrategy(data *imap.SelectData, index *dedupIndex, candidates int) string {
+	if dt.keyMode != DuplicateKeyMessageID || dt.searchThreshold <= 0 || int(data.NumMessages) < dt.searchThreshold {
+		return duplicateStrategyIndex
+	}
+
+	uncached := int(data.NumMessages)
+	if index.UIDValidity == data.UIDValidity && index.Key == dt.keyMode && index.UIDNext > 0 && data.UIDNext >= index.UIDNext {
+		uncached = int(data.UIDNext - index.UIDNext)
+	}
+	if candidates*10 < uncached {
+		return duplicateStrategySearch
+	}
+	return duplicateStrategyIndex
+}
+
+// probeMessageIDs procura os Message-IDs na pasta selecionada do destino, em lotes combinados
+// com OR. Como o SEARCH só devolve UIDs, os envelopes das mensagens encontradas são obtidos
+// para saber que Message-IDs existem. Devolve quantos foram encontrados.
+func (dt *DuplicateTracker) probeMessageIDs(client *imapclient.Client, account, folderName string, messageIDs []string) (int, error) {
+	wanted := make(map[string]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		wanted[id] = true
+	}
+
+	origin := duplicateOrigin{Folder: folderName, Search: true}
+	found := 0
+	for start := 0; start < len(messageIDs); start += duplicateSearchBatch {
+		end := min(start+duplicateSearchBatch, len(messageIDs))
+		criteria := headerSearchCriteria("Message-ID", messageIDs[start:end])
+		data, err := client.UIDSearch(&criteria, nil).Wait()
+		if err != nil {
+			return found, err
+		}
+		uids := data.AllUIDs()
+		if len(uids) == 0 {
+			continue
+		}
+
+		// SEARCH HEADER procura substrings: confirmar com o envelope
+		messages, err := client.Fetch(imap.UIDSetNum(uids...), &imap.FetchOptions{UID: true, Envelope: true}).Collect()
+		if err != nil {
+			return found, err
+		}
+		dt.mu.Lock()
+		for _, msg := range messages {
+			if msg.Envelope == nil || !wanted[msg.Envelope.MessageID] {
+				continue
+			}
+			key := dt.key(account, folderName, msg.Envelope.MessageID)
+			if _, seen := dt.hashes[key]; !seen {
+				dt.hashes[key] = origin
+				found++
+			}
+		}
+		dt.mu.Unlock()
+	}
+	return found, nil
+}
+
+// headerSearchCriteria combina com OR um critério HEADER por valor, numa árvore equilibrada
+// para não criar comandos demasiado aninhados.
+func headerSearchCriteria(field string, values []string) imap.SearchCriteria {
+	if len(values) == 1 {
+		return imap.SearchCriteria{Header: []imap.SearchCriteriaHeaderField{{Key: field, Value: values[0]}}}
+	}
+	mid := len(values) / 2
+	return imap.SearchCriteria{Or: [][2]imap.SearchCriteria{{
+		headerSearchCriteria(field, values[:mid]),
+		headerSearchCriteria(field, values[mid:]),
+	}}}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+func TestHeaderSearchCriteria(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    []string
+		wantDepth int
+	}{
+		{name: "um valor", values: []string{"<1@test>"}, wantDepth: 0},
+		{name: "dois valores", values: []string{"<1@test>", "<2@test>"}, wantDepth: 1},
+		{name: "três valores", values: []string{"<1@test>", "<2@test>", "<3@test>"}, wantDepth: 2},
+		{name: "lote de 50", values: messageIDs(50), wantDepth: 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			criteria := headerSearchCriteria("Message-ID", tt.values)
+			var got []string
+			depth := collectHeaderValues(t, criteria, 0, &got)
+			if !slices.Equal(got, tt.values) {
+				t.Errorf("valores %v, esperado %v", got, tt.values)
+			}
+			if depth != tt.wantDepth {
+				t.Errorf("profundidade %d, esperado %d", depth, tt.wantDepth)
+			}
+		})
+	}
+}
+
+// collectHeaderValues percorre a árvore de OR, junta os valores dos critérios HEADER pela
+// ordem e devolve a profundidade máxima.
+func collectHeaderValues(t *testing.T, criteria imap.SearchCriteria, depth int, values *[]string) int {
+	t.Helper()
+	if len(criteria.Or) == 0 {
+		if len(criteria.Header) != 1 || criteria.Header[0].Key != "Message-ID" {
+			t.Fatalf("folha inesperada: %+v", criteria)
+		}
+		*values = append(*values, criteria.Header[0].Value)
+		return depth
+	}
+	if len(criteria.Or) != 1 || len(criteria.Header) != 0 {
+		t.Fatalf("nó inesperado: %+v", criteria)
+	}
+	left := collectHeaderValues(t, criteria.Or[0][0], depth+1, values)
+	right := collectHeaderValues(t, criteria.Or[0][1], depth+1, values)
+	return max(left, right)
+}
+
+func messageIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = "<" + string(rune('a'+i%26)) + string(rune('a'+i/26)) + "@test>"
+	}
+	return ids
+}
@@ -3,78 +3,166 @@ package main
 import (
 	"crypto/md5"
 	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapclient"
 )
 
+// Âmbitos da detecção de duplicados: onde uma mensagem tem de já existir para ser pulada.
+const (
+	DuplicateScopeFolder  = "folder"  // na mesma pasta do destino (padrão)
+	DuplicateScopeAccount = "account" // em qualquer pasta da mesma conta de origem
+	DuplicateScopeGlobal  = "global"  // em qualquer pasta da conta de destino, incluindo as vindas de outras linhas do CSV
+)
+
 // DuplicateTracker rastreia mensagens já copiadas para evitar duplicados.
 type DuplicateTracker struct {
 	mu      sync.Mutex
-	hashes  map[string]bool // hash -> já copiado
+	scope   string
+	keyMode string            // DuplicateKey*: o que identifica uma mensagem
+	indexDir string           // onde guardar os índices das pastas do destino ("" = só em memória)
+	searchThreshold int       // tamanho mínimo de pasta para procurar com SEARCH em vez de indexar (<= 0 = nunca)
+	hashes  map[string]duplicateOrigin // chave do âmbito -> onde a mensagem foi vista
+}
+
+// duplicateOrigin diz onde uma mensagem duplicada foi vista: já no destino (índice ou SEARCH)
+// ou copiada antes nesta execução, de uma pasta de uma conta de origem.
+type duplicateOrigin struct {
+	Folder  string // pasta do destino, ou pasta de origem da cópia anterior
+	Account string // conta de origem da cópia anterior; vazio se já estava no destino
+	Search  bool   // encontrada com SEARCH
+}
+
+// String descreve a origem para os logs.
+func (o duplicateOrigin) String() string {
+	switch {
+	case o.Account != "":
+		return fmt.Sprintf("copiada antes da pasta '%s' de %s", o.Folder, o.Account)
+	case o.Search:
+		return fmt.Sprintf("já existe na pasta '%s' do destino (SEARCH)", o.Folder)
+	}
+	return fmt.Sprintf("já existe na pasta '%s' do destino", o.Folder)
 }
 
-// NewDuplicateTracker cria um novo rastreador de duplicados.
-func NewDuplicateTracker() *DuplicateTracker {
+// Report descreve a origem para o relatório.
+func (o duplicateOrigin) Report() string {
+	switch {
+	case o.Account != "":
+		return fmt.Sprintf("copied earlier from %s folder '%s'", o.Account, o.Folder)
+	case o.Search:
+		return fmt.Sprintf("already in destination folder '%s' (SEARCH)", o.Folder)
+	}
+	return fmt.Sprintf("already in destination folder '%s'", o.Folder)
+}
+
+// NewDuplicateTracker cria um novo rastreador de duplicados com o âmbito e a chave indicados.
+// indexDir é o diretório dos índices persistentes do destino, ou vazio para não os guardar.
+func NewDuplicateTracker(scope, keyMode, indexDir string, searchThreshold int) *DuplicateTracker {
 	return &DuplicateTracker{
-		hashes: make(map[string]bool),
+		scope:           scope,
+		keyMode:         keyMode,
+		indexDir:        indexDir,
+		searchThreshold: searchThreshold,
+		hashes:          make(map[string]duplicateOrigin),
+	}
+}
+
+// UsesContent indica se as mensagens são identificadas pela impressão digital do conteúdo,
+// que só pode ser calculada depois de obter o corpo.
+func (dt *DuplicateTracker) UsesContent() bool {
+	return dt.keyMode == DuplicateKeyContent || dt.keyMode == DuplicateKeyRaw
+}
+
+// Scope devolve o âmbito da detecção de duplicados.
+func (dt *DuplicateTracker) Scope() string {
+	return dt.scope
+}
+
+// key combina o identificador da mensagem com a conta de origem ou a pasta do destino,
+// conforme o âmbito.
+func (dt *DuplicateTracker) key(account, folderName, messageID string) string {
+	switch dt.scope {
+	case DuplicateScopeGlobal:
+		return messageID
+	case DuplicateScopeAccount:
+		return strings.ToLower(account) + "\x00" + messageID
+	default:
+		return strings.ToLower(folderName) + "\x00" + messageID
 	}
 }
 
-// BuildExistingMessagesIndex constrói um índice das mensagens já existentes no destino.
-func (dt *DuplicateTracker) BuildExistingMessagesIndex(client *imapclient.Client, folderName string) error {
+// BuildExistingMessagesIndex prepara a detecção de duplicados para uma pasta do destino.
+// account é a conta de origem que está a ser migrada e candidates os Message-IDs das
+// mensagens que se pretende copiar. Conforme chooseStrategy, indexa a pasta inteira (com
+// indexDir, o índice é guardado no disco e só os UIDs novos desde a última execução são
+// obtidos do servidor) ou procura apenas os candidatos com SEARCH.
+func (dt *DuplicateTracker) BuildExistingMessagesIndex(client *imapclient.Client, account, folderName string, candidates []string) (duplicateIndexStats, error) {
+	stats := duplicateIndexStats{Strategy: duplicateStrategyIndex}
+	
 	// Selecionar a pasta
 	selectData, err := client.Select(folderName, &imap.SelectOptions{ReadOnly: true}).Wait()
 	if err != nil {
-		return fmt.Errorf("erro ao selecionar pasta para indexação: %w", err)
-	}
-	
-	if selectData.NumMessages == 0 {
-		return nil // Pasta vazia, nada a indexar
+		return stats, fmt.Errorf("erro ao selecionar pasta para indexação: %w", err)
 	}
 	
-	// Buscar Message-IDs de todas as mensagens
-	uidSet := imap.UIDSet{}
-	uidSet.AddRange(1, selectData.UIDNext-1)
-	
-	fetchOptions := &imap.FetchOptions{
-		Envelope: true,
+	index := loadDedupIndex(dt.indexDir, folderName)
+	stats.Strategy = dt.chooseStrategy(selectData, index, len(candidates))
+	if stats.Strategy == duplicateStrategySearch {
+		stats.Probed = len(candidates)
+		stats.Found, err = dt.probeMessageIDs(client, account, folderName, candidates)
+		if err != nil {
+			return stats, fmt.Errorf("erro ao procurar duplicados com SEARCH: %w", err)
+		}
+		return stats, nil
 	}
 	
-	messages, err := client.Fetch(uidSet, fetchOptions).Collect()
+	stats.Fetched, err = index.update(client, selectData, dt.keyMode)
 	if err != nil {
-		return fmt.Errorf("erro ao buscar mensagens para indexação: %w", err)
+		return stats, fmt.Errorf("erro ao buscar mensagens para indexação: %w", err)
+	}
+	if dt.indexDir != "" {
+		if err := index.save(dt.indexDir, folderName); err != nil {
+			return stats, err
+		}
 	}
 	
 	dt.mu.Lock()
 	defer dt.mu.Unlock()
 	
-	for _, msg := range messages {
-		if msg.Envelope != nil && msg.Envelope.MessageID != "" {
-			// Usar Message-ID como identificador único
-			dt.hashes[msg.Envelope.MessageID] = true
+	origin := duplicateOrigin{Folder: folderName}
+	for _, messageID := range index.Messages {
+		if messageID == "" {
+			continue
+		}
+		key := dt.key(account, folderName, messageID)
+		if _, seen := dt.hashes[key]; !seen {
+			dt.hashes[key] = origin
 		}
 	}
 	
-	return nil
+	stats.Indexed = len(index.Messages)
+	return stats, nil
 }
 
-// IsDuplicate verifica se uma mensagem já foi copiada.
-func (dt *DuplicateTracker) IsDuplicate(messageID string) bool {
+// IsDuplicate verifica se uma mensagem já foi copiada ou já existe no âmbito configurado.
+// Devolve também onde foi vista, para o relatório.
+func (dt *DuplicateTracker) IsDuplicate(account, folderName, messageID string) (duplicateOrigin, bool) {
 	if messageID == "" {
 		// Se não há Message-ID, considerar como não duplicado
-		return false
+		return duplicateOrigin{}, false
 	}
 	
 	dt.mu.Lock()
 	defer dt.mu.Unlock()
 	
-	return dt.hashes[messageID]
+	origin, ok := dt.hashes[dt.key(account, folderName, messageID)]
+	return origin, ok
 }
 
-// MarkAsCopied marca uma mensagem como copiada.
-func (dt *DuplicateTracker) MarkAsCopied(messageID string) {
+// MarkAsCopied marca uma mensagem como copiada de uma pasta de origem para folderName.
+func (dt *DuplicateTracker) MarkAsCopied(account, sourceFolder, folderName, messageID string) {
 	if messageID == "" {
 		return
 	}
@@ -82,7 +170,10 @@ func (dt *DuplicateTracker) MarkAsCopied(messageID string) {
 	dt.mu.Lock()
 	defer dt.mu.Unlock()
 	
-	dt.hashes[messageID] = true
+	key := dt.key(account, folderName, messageID)
+	if _, seen := dt.hashes[key]; !seen {
+		dt.hashes[key] = duplicateOrigin{Folder: sourceFolder, Account: account}
+	}
 }
 
 // GenerateMessageHash gera um hash único para uma mensagem baseado em múltiplos campos.
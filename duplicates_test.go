@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+func TestDuplicateTrackerMarksOnlyCopiedMessages(t *testing.T) {
+	for _, scope := range []string{DuplicateScopeFolder, DuplicateScopeAccount, DuplicateScopeGlobal} {
+		dt := NewDuplicateTracker(scope, DuplicateKeyMessageID, "", 0)
+		if _, dup := dt.IsDuplicate("a@test", "INBOX", "x@test"); dup {
+			t.Errorf("%s: duplicado antes de qualquer cópia", scope)
+		}
+		dt.MarkAsCopied("a@test", "INBOX", "INBOX", "x@test")
+		if _, dup := dt.IsDuplicate("a@test", "INBOX", "x@test"); !dup {
+			t.Errorf("%s: não é duplicado depois de copiado", scope)
+		}
+		_, otherFolder := dt.IsDuplicate("a@test", "Arquivo", "x@test")
+		_, otherAccount := dt.IsDuplicate("b@test", "Arquivo", "x@test")
+		if otherFolder != (scope != DuplicateScopeFolder) || otherAccount != (scope == DuplicateScopeGlobal) {
+			t.Errorf("%s: outra pasta %v, outra conta %v", scope, otherFolder, otherAccount)
+		}
+	}
+}
+
+// Duas mensagens com o mesmo Message-ID na mesma pasta: a segunda só é pulada se a primeira
+// chegou de facto ao destino.
+func TestMigrateAccountSkipsRepeatedMessageAfterAppend(t *testing.T) {
+	chdirTemp(t)
+
+	for _, failFirst := range []bool{false, true} {
+		source := newTestServer(t, nil, nil)
+		dest := newTestServer(t, nil, nil)
+		source.addMessage(t, "INBOX", testMessage("<x@test>", "primeira"))
+		source.addMessage(t, "INBOX", testMessage("<x@test>", "segunda"))
+		source.addMessage(t, "INBOX", testMessage("<y@test>", "outra"))
+		if failFirst {
+			dest.failNextAppends(1)
+		}
+
+		acc := MigrationAccount{
+			SourceEmail: "origem@test", SourceUser: "user", SourcePass: "pass", SourceHost: source.Host,
+			DestinationEmail: "destino@test", DestinationUser: "user", DestinationPass: "pass", DestinationHost: dest.Host,
+			SourceConnection: source.options(), DestinationConnection: dest.options(),
+		}
+		config := loadTestConfig(t, `{"skip_duplicates": true, "state_dir": "", "max_retries": 0}`)
+		group := groupAccountsByDestination([]MigrationAccount{acc}, config)[0]
+		if err := migrateAccount(acc, config, group); err != nil {
+			t.Fatal(err)
+		}
+
+		mailbox, err := dest.User.Status("INBOX", &imap.StatusOptions{NumMessages: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *mailbox.NumMessages != 2 {
+			t.Errorf("falha no primeiro APPEND = %v: destino tem %d mensagens, esperado 2; comandos: %v",
+				failFirst, *mailbox.NumMessages, dest.Commands())
+		}
+	}
+}
+
+// O relatório é em inglês e os logs em português.
+func TestDuplicateOriginText(t *testing.T) {
+	tests := []struct {
+		origin         duplicateOrigin
+		report, logged string
+	}{
+		{duplicateOrigin{Folder: "INBOX"}, "already in destination folder 'INBOX'", "já existe na pasta 'INBOX' do destino"},
+		{duplicateOrigin{Folder: "INBOX", Search: true}, "already in destination folder 'INBOX' (SEARCH)", "já existe na pasta 'INBOX' do destino (SEARCH)"},
+		{duplicateOrigin{Folder: "Sent", Account: "a@test"}, "copied earlier from a@test folder 'Sent'", "copiada antes da pasta 'Sent' de a@test"},
+	}
+	for _, tt := range tests {
+		if got := tt.origin.Report(); got != tt.report {
+			t.Errorf("Report() = %q, esperado %q", got, tt.report)
+		}
+		if got := tt.origin.String(); got != tt.logged {
+			t.Errorf("String() = %q, esperado %q", got, tt.logged)
+		}
+	}
+}
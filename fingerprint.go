@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"strings"
+)
+
+// Chaves possíveis da detecção de duplicados.
+const (
+	DuplicateKeyMessageID = "message-id" // Message-ID, ou hash MD5 do envelope se não houver (padrão)
+	DuplicateKeyContent   = "content"    // SHA-256 dos cabeçalhos normalizados e do corpo
+	DuplicateKeyRaw       = "raw"        // SHA-256 da mensagem byte a byte
+)
+
+// volatileHeaders são cabeçalhos que alguns servidores acrescentam ou reescrevem ao guardar
+// a mensagem (estado do mbox, contagens), e que ficam de fora da impressão digital "content".
+var volatileHeaders = map[string]bool{
+	"status":         true,
+	"x-status":       true,
+	"x-keywords":     true,
+	"x-uid":          true,
+	"x-imap":         true,
+	"x-imapbase":     true,
+	"content-length": true,
+	"lines":          true,
+}
+
+// contentFingerprint calcula a impressão digital SHA-256 de uma mensagem lida de r. Com
+// DuplicateKeyContent, os nomes dos cabeçalhos ficam em minúsculas, os cabeçalhos dobrados
+// são juntados, os cabeçalhos voláteis são ignorados e as quebras de linha do corpo são
+// normalizadas para CRLF, sem linhas vazias no fim; assim a mesma mensagem dá o mesmo
+// resultado na origem e no destino, mesmo que o servidor a tenha regravado.
+func contentFingerprint(r io.Reader, key string) (string, error) {
+	h := sha256.New()
+	if key == DuplicateKeyRaw {
+		if _, err := io.Copy(h, r); err != nil {
+			return "", err
+		}
+		return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	br := bufio.NewReader(r)
+	if err := hashHeaders(h, br); err != nil {
+		return "", err
+	}
+	h.Write([]byte("\r\n"))
+	if err := hashBody(h, br); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashHeaders normaliza o cabeçalho até à primeira linha vazia.
+func hashHeaders(h hash.Hash, br *bufio.Reader) error {
+	var field string
+	flush := func() {
+		if field == "" {
+			return
+		}
+		name, value, _ := strings.Cut(field, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !volatileHeaders[name] {
+			h.Write([]byte(name + ":" + strings.Join(strings.Fields(value), " ") + "\r\n"))
+		}
+		field = ""
+	}
+
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		trimmed := strings.TrimRight(line, "\r\n")
+		switch {
+		case trimmed == "" && line != "":
+			flush()
+			return nil
+		case strings.HasPrefix(trimmed, " ") || strings.HasPrefix(trimmed, "\t"):
+			field += " " + trimmed // continuação de um cabeçalho dobrado
+		default:
+			flush()
+			field = trimmed
+		}
+		if errors.Is(err, io.EOF) {
+			flush()
+			return nil
+		}
+	}
+}
+
+// hashBody normaliza as quebras de linha do corpo para CRLF e ignora as linhas vazias finais.
+func hashBody(h hash.Hash, br *bufio.Reader) error {
+	blank := 0
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			if err == nil {
+				blank++
+			}
+		} else {
+			for ; blank > 0; blank-- {
+				h.Write([]byte("\r\n"))
+			}
+			h.Write(line)
+			h.Write([]byte("\r\n"))
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContentFingerprint(t *testing.T) {
+	const base = "From: a@test\r\nSubject: Olá\r\nMessage-ID: <1@test>\r\n\r\nlinha 1\r\nlinha 2\r\n"
+
+	tests := []struct {
+		name    string
+		key     string
+		message string
+		same    bool // mesma impressão digital que base
+	}{
+		{name: "igual", key: DuplicateKeyContent, message: base, same: true},
+		{name: "quebras LF", key: DuplicateKeyContent, message: strings.ReplaceAll(base, "\r\n", "\n"), same: true},
+		{name: "nomes em minúsculas", key: DuplicateKeyContent, message: strings.Replace(base, "Subject:", "subject:", 1), same: true},
+		{name: "espaços no valor", key: DuplicateKeyContent, message: strings.Replace(base, "Subject: Olá", "Subject:   Olá  ", 1), same: true},
+		{name: "cabeçalho dobrado", key: DuplicateKeyContent, message: strings.Replace(base, "From: a@test", "From:\r\n a@test", 1), same: true},
+		{name: "cabeçalho volátil", key: DuplicateKeyContent, message: strings.Replace(base, "\r\n\r\n", "\r\nStatus: RO\r\nX-UID: 7\r\n\r\n", 1), same: true},
+		{name: "linhas vazias no fim", key: DuplicateKeyContent, message: base + "\r\n\r\n", same: true},
+		{name: "linha vazia no meio", key: DuplicateKeyContent, message: strings.Replace(base, "linha 1\r\n", "linha 1\r\n\r\n", 1)},
+		{name: "assunto diferente", key: DuplicateKeyContent, message: strings.Replace(base, "Olá", "Ola", 1)},
+		{name: "corpo diferente", key: DuplicateKeyContent, message: strings.Replace(base, "linha 2", "linha 3", 1)},
+		{name: "raw igual", key: DuplicateKeyRaw, message: base, same: true},
+		{name: "raw com quebras LF", key: DuplicateKeyRaw, message: strings.ReplaceAll(base, "\r\n", "\n")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := contentFingerprint(strings.NewReader(base), tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := contentFingerprint(strings.NewReader(tt.message), tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == want) != tt.same {
+				t.Errorf("impressão digital %s, base %s; esperado iguais = %v", got, want, tt.same)
+			}
+		})
+	}
+}
+
+// Sem linha vazia a separar, a mensagem é só cabeçalho e não pode coincidir com o corpo.
+func TestContentFingerprintHeaderOnly(t *testing.T) {
+	headerOnly, err := contentFingerprint(strings.NewReader("Subject: x"), DuplicateKeyContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodyOnly, err := contentFingerprint(strings.NewReader("\r\nSubject: x"), DuplicateKeyContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headerOnly == bodyOnly {
+		t.Errorf("cabeçalho e corpo com o mesmo texto dão a mesma impressão digital %s", headerOnly)
+	}
+}
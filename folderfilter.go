@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// folderRule é uma regra de include_folders ou exclude_folders. Uma regra pode ser:
+//   - um nome exato ("INBOX.Sent", "[Gmail]/Spam")
+//   - um glob com * (qualquer sequência, incluindo o delimitador) e ? (um carácter):
+//     "INBOX.Archive.*"
+//   - uma expressão regular com o prefixo "re:", que tem de corresponder ao nome completo:
+//     "re:Projetos/20[0-9]{2}"
+type folderRule struct {
+	Pattern string // a regra tal como escrita na configuração
+	Kind    string // "nome", "glob" ou "regex"
+	re      *regexp.Regexp
+}
+
+// compileFolderRules compila as regras de uma lista de filtros de pastas.
+func compileFolderRules(patterns []string, ignoreCase bool) ([]folderRule, error) {
+	flags := ""
+	if ignoreCase {
+		flags = "(?i)"
+	}
+
+	rules := make([]folderRule, 0, len(patterns))
+	for _, pattern := range patterns {
+		rule := folderRule{Pattern: pattern}
+		var expr string
+		switch {
+		case strings.HasPrefix(pattern, "re:"):
+			rule.Kind = "regex"
+			expr = "^(?:" + strings.TrimPrefix(pattern, "re:") + ")$"
+		case strings.ContainsAny(pattern, "*?"):
+			rule.Kind = "glob"
+			expr = globToRegexp(pattern)
+		default:
+			rule.Kind = "nome"
+			expr = "^" + regexp.QuoteMeta(pattern) + "$"
+		}
+
+		re, err := regexp.Compile(flags + expr)
+		if err != nil {
+			return nil, fmt.Errorf("regra de pasta inválida '%s': %w", pattern, err)
+		}
+		rule.re = re
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// globToRegexp converte um glob (* e ?) numa expressão regular ancorada.
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// matchFolderRules devolve a primeira regra que corresponde ao nome da pasta.
+func matchFolderRules(rules []folderRule, folderName string) (folderRule, bool) {
+	for _, rule := range rules {
+		if rule.re.MatchString(folderName) {
+			return rule, true
+		}
+	}
+	return folderRule{}, false
+}
+
+// String descreve a regra para os logs.
+func (r folderRule) String() string {
+	return fmt.Sprintf("'%s' (%s)", r.Pattern, r.Kind)
+}
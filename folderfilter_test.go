@@ -0,0 +1,74 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob string
+		want string
+	}{
+		{"INBOX.*", `^INBOX\..*$`},
+		{"Arquivo 20??", `^Arquivo 20..$`},
+		{"[Gmail]/*", `^\[Gmail\]/.*$`},
+		{"a+b(c)", `^a\+b\(c\)$`},
+		{"Ações*", `^Ações.*$`},
+	}
+	for _, tt := range tests {
+		got := globToRegexp(tt.glob)
+		if got != tt.want {
+			t.Errorf("globToRegexp(%q) = %q, esperado %q", tt.glob, got, tt.want)
+		}
+		if _, err := regexp.Compile(got); err != nil {
+			t.Errorf("globToRegexp(%q) inválida: %v", tt.glob, err)
+		}
+	}
+}
+
+func TestMatchFolderRules(t *testing.T) {
+	tests := []struct {
+		rule       string
+		ignoreCase bool
+		folder     string
+		want       bool
+	}{
+		{"[Gmail]/Spam", false, "[Gmail]/Spam", true},
+		{"[Gmail]/Spam", false, "[Gmail]/Spam/old", false},
+		{"INBOX.Sent", false, "inbox.sent", false},
+		{"INBOX.Sent", true, "inbox.sent", true},
+
+		{"INBOX.Archive.*", false, "INBOX.Archive.2023", true},
+		{"INBOX.Archive.*", false, "INBOX.Archive.2023.Q1", true},
+		{"INBOX.Archive.*", false, "INBOX.Archive", false},
+		{"Arquivo 20??", false, "Arquivo 2024", true},
+		{"Arquivo 20??", false, "Arquivo 202", false},
+
+		// re: tem de corresponder ao nome completo
+		{"re:Projetos/20[0-9]{2}", false, "Projetos/2024", true},
+		{"re:Projetos/20[0-9]{2}", false, "Projetos/2024/Q1", false},
+		{"re:Projetos/20[0-9]{2}", false, "Antigos/Projetos/2024", false},
+		{"re:Projetos/.*", false, "Projetos/2024/Q1", true},
+		{"re:Spam|Junk", false, "Junk", true},
+		{"re:Spam|Junk", false, "Junk E-mail", false},
+		{"re:Spam|Junk", false, "NoSpam", false},
+		{"re:^Projetos/.*$", false, "Projetos/a", true},
+		{"re:projetos/.*", true, "Projetos/a", true},
+	}
+	for _, tt := range tests {
+		rules, err := compileFolderRules([]string{tt.rule}, tt.ignoreCase)
+		if err != nil {
+			t.Fatalf("compileFolderRules(%q): %v", tt.rule, err)
+		}
+		if _, got := matchFolderRules(rules, tt.folder); got != tt.want {
+			t.Errorf("regra %q com pasta %q = %v, esperado %v", tt.rule, tt.folder, got, tt.want)
+		}
+	}
+}
+
+func TestCompileFolderRulesInvalidRegexp(t *testing.T) {
+	if _, err := compileFolderRules([]string{"re:Projetos/("}, false); err == nil {
+		t.Error("esperado erro para expressão regular inválida")
+	}
+}
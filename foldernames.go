@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// mutf7Encoding é o base64 do UTF-7 modificado (RFC 3501, secção 5.1.3): ',' em vez de '/', sem '='.
+var mutf7Encoding = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+,").WithPadding(base64.NoPadding)
+
+var errInvalidMUTF7 = errors.New("UTF-7 modificado inválido")
+
+// decodeModifiedUTF7 converte um nome de pasta em UTF-7 modificado ("A&AOcA9Q-es") para UTF-8.
+func decodeModifiedUTF7(s string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= utf8.RuneSelf {
+			return "", errInvalidMUTF7
+		}
+		if ch != '&' {
+			sb.WriteByte(ch)
+			continue
+		}
+
+		end := strings.IndexByte(s[i+1:], '-')
+		if end < 0 {
+			return "", errInvalidMUTF7
+		}
+		encoded := s[i+1 : i+1+end]
+		i += end + 1
+		if encoded == "" {
+			sb.WriteByte('&') // "&-"
+			continue
+		}
+
+		raw, err := mutf7Encoding.DecodeString(encoded)
+		if err != nil || len(raw)%2 != 0 {
+			return "", errInvalidMUTF7
+		}
+		units := make([]uint16, len(raw)/2)
+		for j := range units {
+			units[j] = uint16(raw[2*j])<<8 | uint16(raw[2*j+1])
+		}
+		sb.WriteString(string(utf16.Decode(units)))
+	}
+	return sb.String(), nil
+}
+
+// utf7Prefix marca, na configuração, um nome de pasta escrito em UTF-7 modificado.
+const utf7Prefix = "utf7:"
+
+// normalizeFolderName devolve o nome de uma pasta em UTF-8. Só os nomes com o prefixo "utf7:"
+// (ex.: "utf7:A&AOcA9Q-es", copiado dos logs de alguns servidores) são descodificados; os
+// restantes já são UTF-8 e ficam como estão, mesmo com '&' ("R&D").
+func normalizeFolderName(name string) (string, error) {
+	encoded, ok := strings.CutPrefix(name, utf7Prefix)
+	if !ok {
+		return name, nil
+	}
+	decoded, err := decodeModifiedUTF7(encoded)
+	if err != nil {
+		return "", fmt.Errorf("%q: %w", name, err)
+	}
+	return decoded, nil
+}
+
+// normalizeFolderNames aplica normalizeFolderName a uma lista de nomes, no próprio slice.
+func normalizeFolderNames(names []string) error {
+	for i, name := range names {
+		normalized, err := normalizeFolderName(name)
+		if err != nil {
+			return err
+		}
+		names[i] = normalized
+	}
+	return nil
+}
+
+// sanitizeFolderName aplica a tabela de substituições (folder_name_substitutions) a um nome
+// de pasta do destino. O delimitador de hierarquia nunca é substituído.
+func sanitizeFolderName(name string, substitutions map[string]string, delim rune) string {
+	if len(substitutions) == 0 {
+		return name
+	}
+
+	// Sequências mais longas primeiro, para um resultado determinístico
+	keys := make([]string, 0, len(substitutions))
+	for k := range substitutions {
+		if k != "" && k != string(delim) {
+			keys = append(keys, k)
+		}
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		if len(a) != len(b) {
+			return len(b) - len(a)
+		}
+		return strings.Compare(a, b)
+	})
+
+	pairs := make([]string, 0, 2*len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k, substitutions[k])
+	}
+	return strings.NewReplacer(pairs...).Replace(name)
+}
+
+// truncateRunes corta s em no máximo n caracteres (não bytes), acrescentando "..." se cortar.
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-3]) + "..."
+}
@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestDecodeModifiedUTF7(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "INBOX", want: "INBOX"},
+		{in: "A&AOcA9Q-es", want: "Ações"},
+		{in: "&ZeVnLIqe-", want: "日本語"},
+		{in: "R&-D", want: "R&D"},
+		{in: "&-", want: "&"},
+		{in: "Projetos/&AMk-poca", want: "Projetos/Época"},
+		{in: "R&D", wantErr: true},
+		{in: "&AOc", wantErr: true},
+		{in: "&A-", wantErr: true},
+		{in: "Ações", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := decodeModifiedUTF7(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("decodeModifiedUTF7(%q) = %q, esperado erro", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("decodeModifiedUTF7(%q) = %q, %v; esperado %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizeFolderName(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "Ações/Relatórios", want: "Ações/Relatórios"},
+		{in: "R&D", want: "R&D"},
+		{in: "R&Dev-Team", want: "R&Dev-Team"},
+		{in: "&-x", want: "&-x"},
+		{in: "utf7:A&AOcA9Q-es", want: "Ações"},
+		{in: "utf7:R&-D", want: "R&D"},
+		{in: "utf7:R&D", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeFolderName(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("normalizeFolderName(%q) = %q, esperado erro", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeFolderName(%q) = %q, %v; esperado %q", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// FolderRewriteRule renomeia pastas de origem com uma expressão regular. O destino pode usar
+// os grupos capturados ($1, ${nome}); o texto capturado tem o delimitador da origem traduzido
+// para o do destino. As regras são avaliadas por ordem e a primeira que corresponder vence.
+type FolderRewriteRule struct {
+	Match   string `json:"match"`   // ex.: "^INBOX\\.Clientes\\.(.+)$"
+	Replace string `json:"replace"` // ex.: "Clientes/$1"
+
+	re *regexp.Regexp
+}
+
+// compileFolderRewriteRules compila as regras de folder_rules.
+func compileFolderRewriteRules(rules []FolderRewriteRule) error {
+	for i := range rules {
+		re, err := regexp.Compile(rules[i].Match)
+		if err != nil {
+			return fmt.Errorf("regra %d ('%s'): %w", i+1, rules[i].Match, err)
+		}
+		rules[i].re = re
+	}
+	return nil
+}
+
+// apply reescreve o nome da pasta, traduzindo os grupos capturados para a hierarquia do destino.
+func (r FolderRewriteRule) apply(name string, src, dst folderNamespace, escape string) (string, bool) {
+	match := r.re.FindStringSubmatchIndex(name)
+	if match == nil {
+		return "", false
+	}
+
+	// Construir um texto só com os grupos traduzidos e índices correspondentes para o Expand
+	var translated strings.Builder
+	indexes := make([]int, len(match))
+	for i := 0; i < len(match); i += 2 {
+		if match[i] < 0 {
+			indexes[i], indexes[i+1] = -1, -1
+			continue
+		}
+		indexes[i] = translated.Len()
+		translated.WriteString(translateSegments(name[match[i]:match[i+1]], src, dst, escape))
+		indexes[i+1] = translated.Len()
+	}
+	return string(r.re.ExpandString(nil, r.Replace, translated.String(), indexes)), true
+}
+
+// folderPlanEntry descreve o destino de uma pasta de origem.
+type folderPlanEntry struct {
+	Source        string
+	Destination   string
+	Origin        planOrigin // o que determinou o destino
+	Role          string     // função de sistema, se a pasta tiver uma
+	Exists        bool       // pasta de sistema que já existe no destino
+	CreateOptions *imap.CreateOptions
+}
+
+// planOrigin diz o que determinou o destino de uma pasta no plano.
+type planOrigin struct {
+	Mapping  bool   // folder_mapping
+	Rule     int    // número (1..n) da regra de folder_rules; 0 se nenhuma
+	Role     string // função de sistema
+	Suffixed bool   // renomeada com sufixo por colisão
+}
+
+// String descreve a origem para os logs.
+func (o planOrigin) String() string {
+	var text string
+	switch {
+	case o.Role != "":
+		text = "pasta de sistema " + o.Role
+	case o.Mapping:
+		text = "folder_mapping"
+	case o.Rule > 0:
+		text = fmt.Sprintf("folder_rules #%d", o.Rule)
+	default:
+		text = "tradução de namespace"
+	}
+	if o.Suffixed {
+		text += ", sufixo por colisão"
+	}
+	return text
+}
+
+// Report descreve a origem para o relatório.
+func (o planOrigin) Report() string {
+	var text string
+	switch {
+	case o.Role != "":
+		text = "system folder " + o.Role
+	case o.Mapping:
+		text = "folder_mapping"
+	case o.Rule > 0:
+		text = fmt.Sprintf("folder_rules #%d", o.Rule)
+	default:
+		text = "namespace translation"
+	}
+	if o.Suffixed {
+		text += ", collision suffix"
+	}
+	return text
+}
+
+// destinationFolderName calcula o nome no destino de uma pasta de origem, antes do flatten.
+// explicit indica que o nome veio de folder_mapping ou folder_rules e prevalece sobre as
+// pastas de sistema.
+func (c *MigrationConfig) destinationFolderName(name string, src, dst folderNamespace) (dest string, origin planOrigin, explicit bool) {
+	if mapped, ok := c.FolderMapping[name]; ok {
+		return mapped, planOrigin{Mapping: true}, true
+	}
+	for i, rule := range c.FolderRules {
+		if dest, ok := rule.apply(name, src, dst, c.DelimiterEscape); ok {
+			return dest, planOrigin{Rule: i + 1}, true
+		}
+	}
+	return translateFolderName(name, src, dst, c.DelimiterEscape), planOrigin{}, false
+}
+
+// buildFolderPlan calcula, antes de copiar, o destino de cada pasta de origem selecionável
+// que passe os filtros. destRoles é atualizado com as pastas de sistema que serão criadas.
+// Com um prefixo de destino (ver destinationPrefix), todas as pastas ficam debaixo dele e as
+// pastas de sistema não são encaminhadas para as do destino.
+func buildFolderPlan(email string, mailboxes []*imap.ListData, config MigrationConfig, sourceNS, destNS folderNamespace, destRoles map[string]string, createSpecialUse bool, prefix string) []folderPlanEntry {
+	var plan []folderPlanEntry
+	for _, mb := range mailboxes {
+		if slices.Contains(mb.Attrs, imap.MailboxAttrNoSelect) {
+			log.Printf("[%s] Ignorando pasta não selecionável: %s", email, mb.Mailbox)
+			continue
+		}
+
+		// Filtro de pastas
+		include, reason := config.FolderFilterDecision(mb.Mailbox)
+		if !include {
+			log.Printf("[%s] Pasta '%s' excluída por filtro de configuração: %s", email, mb.Mailbox, reason)
+			continue
+		}
+		if config.DryRun {
+			log.Printf("[%s] [DRY-RUN] Pasta '%s' incluída: %s", email, mb.Mailbox, reason)
+		}
+
+		// Mapeamento explícito ou tradução de prefixo e delimitador; depois flatten e substituições
+		entry := folderPlanEntry{Source: mb.Mailbox}
+		dest, origin, explicit := config.destinationFolderName(mb.Mailbox, sourceNS, destNS)
+		dest = config.FlattenFolderName(dest, destNS)
+		if prefix != "" {
+			dest = nestUnderPrefix(dest, prefix, destNS, config.DelimiterEscape)
+		}
+		entry.Destination = sanitizeFolderName(dest, config.FolderNameSubstitutions, destNS.Delim)
+		entry.Origin = origin
+
+		// Sem mapeamento explícito, pastas de sistema vão para a pasta com a mesma função no destino.
+		// Se o destino não tiver essa pasta, é criada marcada com o atributo SPECIAL-USE.
+		if role := folderRole(mb, config.SystemFolders); role != "" && !explicit && prefix == "" {
+			entry.Role = role
+			entry.Origin = planOrigin{Role: role}
+			if existing, ok := destRoles[role]; ok {
+				entry.Destination = existing
+				entry.Exists = true
+			} else {
+				if createSpecialUse {
+					entry.CreateOptions = &imap.CreateOptions{SpecialUse: []imap.MailboxAttr{roleAttrs[role]}}
+				}
+				destRoles[role] = entry.Destination
+			}
+		}
+
+		plan = append(plan, entry)
+	}
+	return plan
+}
+
+// destinationPrefix expande o modelo de prefixo da conta (coluna prefixo_destino, ou
+// destination_prefix da configuração) para a hierarquia do destino. O modelo usa sempre '/'
+// como separador; os valores substituídos ({source_email}, {source_user}, {source_local},
+// {source_domain}, {destination_email}) têm o delimitador do destino escapado, para que
+// "joao@old.com" não se torne em várias pastas num servidor que usa '.'.
+func (acc MigrationAccount) destinationPrefix(config MigrationConfig, destNS folderNamespace) string {
+	template := config.DestinationPrefix
+	if acc.DestinationPrefix != "" {
+		template = acc.DestinationPrefix
+	}
+	if template == "" || template == "none" {
+		return ""
+	}
+
+	local, domain, _ := strings.Cut(acc.SourceEmail, "@")
+	escape := func(value string) string {
+		if destNS.Delim == 0 {
+			return value
+		}
+		return strings.ReplaceAll(value, string(destNS.Delim), config.DelimiterEscape)
+	}
+	replacer := strings.NewReplacer(
+		"{source_email}", escape(acc.SourceEmail),
+		"{source_user}", escape(acc.SourceUser),
+		"{source_local}", escape(local),
+		"{source_domain}", escape(domain),
+		"{destination_email}", escape(acc.DestinationEmail),
+	)
+
+	var segments []string
+	for _, segment := range strings.Split(template, "/") {
+		if segment = strings.TrimSpace(segment); segment != "" {
+			segments = append(segments, replacer.Replace(segment))
+		}
+	}
+
+	separator := string(destNS.Delim)
+	if destNS.Delim == 0 {
+		separator = config.DelimiterEscape
+	}
+	return strings.Join(segments, separator)
+}
+
+// nestUnderPrefix coloca uma pasta do destino debaixo do prefixo da conta, dentro do namespace
+// pessoal. INBOX passa a ser uma subpasta normal chamada "INBOX".
+func nestUnderPrefix(dest, prefix string, destNS folderNamespace, escape string) string {
+	if destNS.Prefix != "" && len(dest) >= len(destNS.Prefix) && strings.EqualFold(dest[:len(destNS.Prefix)], destNS.Prefix) {
+		dest = dest[len(destNS.Prefix):]
+	}
+	separator := string(destNS.Delim)
+	if destNS.Delim == 0 {
+		separator = escape
+	}
+	return destNS.Prefix + prefix + separator + dest
+}
+
+// formatFolderPlan apresenta o plano como a árvore de pastas do destino, com a origem de cada uma.
+func formatFolderPlan(plan []folderPlanEntry, destNS folderNamespace) string {
+	sorted := slices.Clone(plan)
+	slices.SortStableFunc(sorted, func(a, b folderPlanEntry) int {
+		return strings.Compare(a.Destination, b.Destination)
+	})
+
+	var sb strings.Builder
+	for _, entry := range sorted {
+		depth := 0
+		if destNS.Delim != 0 {
+			depth = strings.Count(strings.TrimPrefix(entry.Destination, destNS.Prefix), string(destNS.Delim))
+		}
+		fmt.Fprintf(&sb, "  %s%s  <-  %s  [%s]\n", strings.Repeat("  ", depth), entry.Destination, entry.Source, entry.Origin)
+	}
+	return sb.String()
+}
+
+// Políticas para pastas de origem diferentes que iriam parar à mesma pasta do destino.
+const (
+	CollisionFail   = "fail"   // abortar a conta antes de copiar
+	CollisionMerge  = "merge"  // juntar as pastas no mesmo destino (comportamento anterior)
+	CollisionSuffix = "suffix" // acrescentar _2, _3, ... às pastas seguintes
+)
+
+// folderCollision regista um grupo de pastas de origem com o mesmo destino e a decisão tomada.
+type folderCollision struct {
+	Destination string
+	Sources     []string
+	Policy      string   // folder_collision_policy aplicada
+	Renamed     []string // com suffix: "'origem' -> 'novo destino'"
+}
+
+// String descreve a colisão para os logs.
+func (c folderCollision) String() string {
+	var decision string
+	switch c.Policy {
+	case CollisionSuffix:
+		decision = "sufixo: " + strings.Join(c.Renamed, ", ")
+	case CollisionFail:
+		decision = "abortado (folder_collision_policy = fail)"
+	default:
+		decision = "juntadas na mesma pasta"
+	}
+	return fmt.Sprintf("'%s' <- '%s': %s", c.Destination, strings.Join(c.Sources, "', '"), decision)
+}
+
+// Report descreve a colisão para o relatório.
+func (c folderCollision) Report() string {
+	var decision string
+	switch c.Policy {
+	case CollisionSuffix:
+		decision = "suffixed: " + strings.Join(c.Renamed, ", ")
+	case CollisionFail:
+		decision = "aborted (folder_collision_policy = fail)"
+	default:
+		decision = "merged into the same folder"
+	}
+	return fmt.Sprintf("'%s' <- '%s': %s", c.Destination, strings.Join(c.Sources, "', '"), decision)
+}
+
+// resolveFolderCollisions deteta pastas de origem que iriam parar à mesma pasta do destino
+// (ignorando maiúsculas, salvo caseSensitive) e aplica a política configurada, alterando o
+// plano no caso de suffix. Pastas de sistema com a mesma função juntam-se sempre: é
+// intencional e não conta como colisão. Os sufixos evitam também os nomes que já existem no
+// destino (existing, da resposta ao LIST).
+func resolveFolderCollisions(plan []folderPlanEntry, existing []*imap.ListData, policy string, caseSensitive bool) ([]folderCollision, error) {
+	key := func(name string) string {
+		if caseSensitive && !strings.EqualFold(name, "INBOX") {
+			return name
+		}
+		return strings.ToLower(name)
+	}
+
+	groups := make(map[string][]int)
+	var order []string
+	for i, entry := range plan {
+		k := key(entry.Destination)
+		if _, seen := groups[k]; !seen {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], i)
+	}
+
+	taken := make(map[string]bool, len(groups)+len(existing))
+	for k := range groups {
+		taken[k] = true
+	}
+	for _, mb := range existing {
+		taken[key(mb.Mailbox)] = true
+	}
+
+	var collisions []folderCollision
+	for _, k := range order {
+		indexes := groups[k]
+		if len(indexes) < 2 {
+			continue
+		}
+
+		// A primeira pasta (de sistema, se houver) fica com o nome; as restantes colidem
+		slices.SortStableFunc(indexes, func(a, b int) int {
+			if (plan[a].Role != "") != (plan[b].Role != "") {
+				if plan[a].Role != "" {
+					return -1
+				}
+				return 1
+			}
+			return 0
+		})
+		first := plan[indexes[0]]
+		var colliding []int
+		for _, i := range indexes[1:] {
+			if first.Role == "" || plan[i].Role != first.Role {
+				colliding = append(colliding, i)
+			}
+		}
+		if len(colliding) == 0 {
+			continue
+		}
+
+		collision := folderCollision{Destination: first.Destination, Sources: []string{first.Source}, Policy: policy}
+		for _, i := range colliding {
+			collision.Sources = append(collision.Sources, plan[i].Source)
+		}
+
+		if policy == CollisionSuffix {
+			for _, i := range colliding {
+				for n := 2; ; n++ {
+					candidate := fmt.Sprintf("%s_%d", plan[i].Destination, n)
+					if !taken[key(candidate)] {
+						taken[key(candidate)] = true
+						plan[i].Destination = candidate
+						plan[i].Origin.Suffixed = true
+						plan[i].Origin.Role = ""
+						plan[i].Exists = false
+						plan[i].Role = ""
+						plan[i].CreateOptions = nil
+						collision.Renamed = append(collision.Renamed, fmt.Sprintf("'%s' -> '%s'", plan[i].Source, candidate))
+						break
+					}
+				}
+			}
+		}
+		collisions = append(collisions, collision)
+	}
+
+	if policy == CollisionFail && len(collisions) > 0 {
+		return collisions, fmt.Errorf("%d colisões de pastas no destino (folder_collision_policy = fail)", len(collisions))
+	}
+	return collisions, nil
+}
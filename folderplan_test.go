@@ -0,0 +1,187 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+func TestResolveFolderCollisions(t *testing.T) {
+	tests := []struct {
+		name          string
+		plan          []folderPlanEntry
+		existing      []string
+		policy        string
+		caseSensitive bool
+		want          []string // destinos depois da resolução
+		collisions    int
+		wantErr       bool
+	}{
+		{
+			name:   "sem colisões",
+			plan:   []folderPlanEntry{{Source: "A", Destination: "A"}, {Source: "B", Destination: "B"}},
+			policy: CollisionSuffix,
+			want:   []string{"A", "B"},
+		},
+		{
+			name:       "merge",
+			plan:       []folderPlanEntry{{Source: "Work", Destination: "Work"}, {Source: "work", Destination: "work"}},
+			policy:     CollisionMerge,
+			want:       []string{"Work", "work"},
+			collisions: 1,
+		},
+		{
+			name:          "maiúsculas distintas no destino",
+			plan:          []folderPlanEntry{{Source: "Work", Destination: "Work"}, {Source: "work", Destination: "work"}},
+			policy:        CollisionSuffix,
+			caseSensitive: true,
+			want:          []string{"Work", "work"},
+		},
+		{
+			name:       "suffix",
+			plan:       []folderPlanEntry{{Source: "A.B_C", Destination: "A_B_C"}, {Source: "A_B.C", Destination: "A_B_C"}, {Source: "A_B_C", Destination: "A_B_C"}},
+			policy:     CollisionSuffix,
+			want:       []string{"A_B_C", "A_B_C_2", "A_B_C_3"},
+			collisions: 1,
+		},
+		{
+			name:       "suffix evita nomes do plano",
+			plan:       []folderPlanEntry{{Source: "x", Destination: "X"}, {Source: "X", Destination: "X"}, {Source: "X_2", Destination: "X_2"}},
+			policy:     CollisionSuffix,
+			want:       []string{"X", "X_3", "X_2"},
+			collisions: 1,
+		},
+		{
+			name:       "suffix evita pastas existentes no destino",
+			plan:       []folderPlanEntry{{Source: "Work", Destination: "Work"}, {Source: "work", Destination: "work"}},
+			existing:   []string{"INBOX", "work_2", "Work_3"},
+			policy:     CollisionSuffix,
+			want:       []string{"Work", "work_4"},
+			collisions: 1,
+		},
+		{
+			name: "pastas de sistema juntam-se",
+			plan: []folderPlanEntry{
+				{Source: "Sent Messages", Destination: "Sent", Role: "sent"},
+				{Source: "Sent", Destination: "Sent", Role: "sent"},
+			},
+			policy: CollisionFail,
+			want:   []string{"Sent", "Sent"},
+		},
+		{
+			name: "pasta normal contra pasta de sistema",
+			plan: []folderPlanEntry{
+				{Source: "sent", Destination: "Sent"},
+				{Source: "Sent Messages", Destination: "Sent", Role: "sent"},
+			},
+			policy:     CollisionSuffix,
+			want:       []string{"Sent_2", "Sent"},
+			collisions: 1,
+		},
+		{
+			name:       "fail",
+			plan:       []folderPlanEntry{{Source: "A", Destination: "X"}, {Source: "B", Destination: "X"}},
+			policy:     CollisionFail,
+			want:       []string{"X", "X"},
+			collisions: 1,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var existing []*imap.ListData
+			for _, name := range tt.existing {
+				existing = append(existing, &imap.ListData{Mailbox: name})
+			}
+			collisions, err := resolveFolderCollisions(tt.plan, existing, tt.policy, tt.caseSensitive)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("erro = %v, esperado erro: %v", err, tt.wantErr)
+			}
+			if len(collisions) != tt.collisions {
+				t.Errorf("%d colisões, esperado %d: %v", len(collisions), tt.collisions, collisions)
+			}
+			for i, entry := range tt.plan {
+				if entry.Destination != tt.want[i] {
+					t.Errorf("destino de '%s' = %q, esperado %q", entry.Source, entry.Destination, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// O relatório é em inglês e os logs em português.
+func TestFolderCollisionText(t *testing.T) {
+	c := folderCollision{Destination: "X", Sources: []string{"A", "B"}, Policy: CollisionSuffix, Renamed: []string{"'B' -> 'X_2'"}}
+	if got, want := c.Report(), "'X' <- 'A', 'B': suffixed: 'B' -> 'X_2'"; got != want {
+		t.Errorf("Report() = %q, esperado %q", got, want)
+	}
+	if got, want := c.String(), "'X' <- 'A', 'B': sufixo: 'B' -> 'X_2'"; got != want {
+		t.Errorf("String() = %q, esperado %q", got, want)
+	}
+
+	origin := planOrigin{Rule: 2, Suffixed: true}
+	if got, want := origin.Report(), "folder_rules #2, collision suffix"; got != want {
+		t.Errorf("Report() = %q, esperado %q", got, want)
+	}
+	if got, want := origin.String(), "folder_rules #2, sufixo por colisão"; got != want {
+		t.Errorf("String() = %q, esperado %q", got, want)
+	}
+}
+
+func TestFolderRewriteRuleApply(t *testing.T) {
+	courier := folderNamespace{Prefix: "INBOX.", Delim: '.'}
+	dovecot := folderNamespace{Delim: '/'}
+
+	tests := []struct {
+		name     string
+		rule     FolderRewriteRule
+		folder   string
+		src, dst folderNamespace
+		want     string
+		matched  bool
+	}{
+		{
+			name:   "grupo com hierarquia",
+			rule:   FolderRewriteRule{Match: `^INBOX\.Clientes\.(.+)$`, Replace: "Clientes/$1"},
+			folder: "INBOX.Clientes.ACME.2024", src: courier, dst: dovecot,
+			want: "Clientes/ACME/2024", matched: true,
+		},
+		{
+			name:   "delimitador do destino dentro do grupo",
+			rule:   FolderRewriteRule{Match: `^Clientes/(.+)$`, Replace: "INBOX.Clientes.$1"},
+			folder: "Clientes/acme.com/Faturas", src: dovecot, dst: courier,
+			want: "INBOX.Clientes.acme_com.Faturas", matched: true,
+		},
+		{
+			name:   "grupo com nome",
+			rule:   FolderRewriteRule{Match: `^Arquivo (?P<ano>\d{4})$`, Replace: "Arquivo/${ano}"},
+			folder: "Arquivo 2023", src: dovecot, dst: dovecot,
+			want: "Arquivo/2023", matched: true,
+		},
+		{
+			name:   "grupo opcional em falta",
+			rule:   FolderRewriteRule{Match: `^Projetos(\.(.+))?$`, Replace: "P[$2]"},
+			folder: "Projetos", src: courier, dst: dovecot,
+			want: "P[]", matched: true,
+		},
+		{
+			name:   "sem correspondência",
+			rule:   FolderRewriteRule{Match: `^Clientes/`, Replace: "X"},
+			folder: "INBOX", src: dovecot, dst: dovecot,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := []FolderRewriteRule{tt.rule}
+			if err := compileFolderRewriteRules(rules); err != nil {
+				t.Fatal(err)
+			}
+			got, matched := rules[0].apply(tt.folder, tt.src, tt.dst, "_")
+			if got != tt.want || matched != tt.matched {
+				t.Errorf("apply(%q) = %q, %v; esperado %q, %v", tt.folder, got, matched, tt.want, tt.matched)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// Funções (roles) das pastas de sistema, pela ordem em que são procuradas.
+var folderRoles = []string{"drafts", "sent", "junk", "trash", "archive"}
+
+// roleAttrs associa cada função ao atributo SPECIAL-USE (RFC 6154) correspondente.
+var roleAttrs = map[string]imap.MailboxAttr{
+	"drafts":  imap.MailboxAttrDrafts,
+	"sent":    imap.MailboxAttrSent,
+	"junk":    imap.MailboxAttrJunk,
+	"trash":   imap.MailboxAttrTrash,
+	"archive": imap.MailboxAttrArchive,
+}
+
+// Aliases retorna os nomes alternativos configurados para uma função.
+func (sf SystemFolders) Aliases(role string) []string {
+	switch role {
+	case "drafts":
+		return sf.Drafts
+	case "sent":
+		return sf.Sent
+	case "junk":
+		return sf.Junk
+	case "trash":
+		return sf.Trash
+	case "archive":
+		return sf.Archive
+	}
+	return nil
+}
+
+// Role retorna a função de uma pasta cujo nome coincide com um dos aliases, ou vazio.
+func (sf SystemFolders) Role(folderName string) string {
+	for _, role := range folderRoles {
+		for _, alias := range sf.Aliases(role) {
+			if strings.EqualFold(alias, folderName) {
+				return role
+			}
+		}
+	}
+	return ""
+}
+
+// folderRole determina a função de uma pasta de origem. Os atributos SPECIAL-USE anunciados
+// pelo servidor prevalecem sobre os aliases, o que reconhece também nomes localizados
+// ("Itens Enviados", "Elementos eliminados").
+func folderRole(mb *imap.ListData, systemFolders SystemFolders) string {
+	for _, role := range folderRoles {
+		if hasMailboxAttr(mb.Attrs, roleAttrs[role]) {
+			return role
+		}
+	}
+	return systemFolders.Role(mb.Mailbox)
+}
+
+// listOptionsFor pede os atributos SPECIAL-USE no LIST quando o servidor os suporta.
+func listOptionsFor(client *imapclient.Client) *imap.ListOptions {
+	if client.Caps().Has(imap.CapSpecialUse) {
+		return &imap.ListOptions{ReturnSpecialUse: true}
+	}
+	return nil
+}
+
+// createFolder cria uma pasta no destino. Se o pedido com CREATE-SPECIAL-USE for recusado,
+// tenta de novo sem atributos.
+func createFolder(client *imapclient.Client, name string, options *imap.CreateOptions) error {
+	err := client.Create(name, options).Wait()
+	if err != nil && options != nil && !isConnectionClosed(err) {
+		err = client.Create(name, nil).Wait()
+	}
+	return err
+}
+
+// discoverRoleFolders devolve a pasta do destino de cada função: a marcada com o atributo
+// SPECIAL-USE, ou então o primeiro alias configurado que exista.
+func discoverRoleFolders(mailboxes []*imap.ListData, systemFolders SystemFolders) map[string]string {
+	roles := make(map[string]string)
+	existing := make(map[string]string) // nome em minúsculas -> nome real
+	for _, mb := range mailboxes {
+		existing[strings.ToLower(mb.Mailbox)] = mb.Mailbox
+		for role, attr := range roleAttrs {
+			if _, found := roles[role]; !found && hasMailboxAttr(mb.Attrs, attr) {
+				roles[role] = mb.Mailbox
+			}
+		}
+	}
+
+	for _, role := range folderRoles {
+		if _, found := roles[role]; found {
+			continue
+		}
+		for _, alias := range systemFolders.Aliases(role) {
+			if name, ok := existing[strings.ToLower(alias)]; ok {
+				roles[role] = name
+				break
+			}
+		}
+	}
+	return roles
+}
+
+// folderNamespace descreve como um servidor organiza as pastas pessoais: o prefixo do
+// namespace pessoal ("INBOX." no Courier, vazio no Dovecot e no Gmail) e o delimitador.
+type folderNamespace struct {
+	Prefix string
+	Delim  rune // 0 = servidor sem hierarquia
+}
+
+// discoverNamespace obtém o namespace pessoal com o comando NAMESPACE (RFC 2342). Sem
+// suporte, o delimitador é lido das respostas ao LIST e assume-se que não há prefixo.
+func discoverNamespace(client *imapclient.Client, mailboxes []*imap.ListData) folderNamespace {
+	if client.Caps().Has(imap.CapNamespace) {
+		data, err := client.Namespace().Wait()
+		if err == nil && len(data.Personal) > 0 {
+			ns := folderNamespace{Prefix: data.Personal[0].Prefix, Delim: data.Personal[0].Delim}
+			if ns.Delim == 0 {
+				ns.Delim = listDelimiter(mailboxes)
+			}
+			return ns
+		}
+	}
+	return folderNamespace{Delim: listDelimiter(mailboxes)}
+}
+
+// listDelimiter devolve o primeiro delimitador de hierarquia anunciado nas respostas ao LIST.
+func listDelimiter(mailboxes []*imap.ListData) rune {
+	for _, mb := range mailboxes {
+		if mb.Delim != 0 {
+			return mb.Delim
+		}
+	}
+	return 0
+}
+
+// String descreve o namespace para os logs.
+func (ns folderNamespace) String() string {
+	delim := "nenhum"
+	if ns.Delim != 0 {
+		delim = fmt.Sprintf("'%c'", ns.Delim)
+	}
+	return fmt.Sprintf("prefixo '%s', delimitador %s", ns.Prefix, delim)
+}
+
+// translateFolderName reescreve um caminho de pasta da origem para o destino, segmento a
+// segmento: retira o prefixo da origem, troca o delimitador e acrescenta o prefixo do destino.
+// Um segmento que contenha o delimitador do destino tem-no substituído por escape, para não
+// criar níveis de hierarquia que não existiam na origem. Quando o prefixo do destino é
+// "INBOX<delim>" (Courier), as filhas da INBOX da origem ficam diretamente sob esse prefixo
+// ("INBOX/child" -> "INBOX.child" e não "INBOX.INBOX.child").
+func translateFolderName(name string, src, dst folderNamespace, escape string) string {
+	if strings.EqualFold(name, "INBOX") {
+		return "INBOX"
+	}
+
+	rest := name
+	personal := src.Prefix == ""
+	if src.Prefix != "" && hasPrefixFold(name, src.Prefix) {
+		rest = name[len(src.Prefix):]
+		personal = true
+	} else if src.Prefix == "" && dst.inboxRooted() && src.Delim != 0 && hasPrefixFold(name, "INBOX"+string(src.Delim)) {
+		rest = name[len("INBOX")+1:]
+	}
+
+	translated := translateSegments(rest, src, dst, escape)
+
+	// Pastas fora do namespace pessoal da origem (partilhadas) mantêm-se sem prefixo
+	if personal && dst.Prefix != "" && !strings.EqualFold(translated, "INBOX") {
+		translated = dst.Prefix + translated
+	}
+	return translated
+}
+
+// inboxRooted indica se o namespace pessoal fica dentro da INBOX (prefixo "INBOX<delim>").
+func (ns folderNamespace) inboxRooted() bool {
+	return ns.Delim != 0 && strings.EqualFold(ns.Prefix, "INBOX"+string(ns.Delim))
+}
+
+// hasPrefixFold verifica se name começa por prefix, ignorando maiúsculas, e tem algo depois.
+func hasPrefixFold(name, prefix string) bool {
+	return len(name) > len(prefix) && strings.EqualFold(name[:len(prefix)], prefix)
+}
+
+// hasMailboxAttr verifica se a lista de atributos inclui attr, ignorando maiúsculas.
+func hasMailboxAttr(attrs []imap.MailboxAttr, attr imap.MailboxAttr) bool {
+	for _, a := range attrs {
+		if strings.EqualFold(string(a), string(attr)) {
+			return true
+		}
+	}
+	return false
+}
+
+// translateSegments troca o delimitador de hierarquia da origem pelo do destino, escapando o
+// delimitador do destino quando aparece dentro de um segmento.
+func translateSegments(path string, src, dst folderNamespace, escape string) string {
+	segments := []string{path}
+	if src.Delim != 0 {
+		segments = strings.Split(path, string(src.Delim))
+	}
+
+	if dst.Delim != 0 && dst.Delim != src.Delim {
+		for i, segment := range segments {
+			segments[i] = strings.ReplaceAll(segment, string(dst.Delim), escape)
+		}
+	}
+
+	separator := string(dst.Delim)
+	if dst.Delim == 0 {
+		separator = escape
+	}
+	return strings.Join(segments, separator)
+}
@@ -0,0 +1,82 @@
+package main
+
+import "testing"
+
+func TestTranslateFolderName(t *testing.T) {
+	courier := folderNamespace{Prefix: "INBOX.", Delim: '.'}
+	dovecot := folderNamespace{Delim: '/'}
+	dovecotDot := folderNamespace{Delim: '.'}
+	flat := folderNamespace{}
+
+	tests := []struct {
+		name     string
+		src, dst folderNamespace
+		want     string
+	}{
+		{"INBOX", courier, dovecot, "INBOX"},
+		{"inbox", dovecot, courier, "INBOX"},
+
+		// Courier -> Dovecot: o prefixo INBOX. é a raiz pessoal
+		{"INBOX.Sent", courier, dovecot, "Sent"},
+		{"INBOX.Projetos.2024", courier, dovecot, "Projetos/2024"},
+		{"INBOX.a/b", courier, dovecot, "a_b"},
+		{"inbox.Sent", courier, dovecot, "Sent"},
+
+		// Dovecot -> Courier: pastas na raiz ficam sob INBOX., filhas da INBOX não duplicam o prefixo
+		{"Sent", dovecot, courier, "INBOX.Sent"},
+		{"Projetos/2024", dovecot, courier, "INBOX.Projetos.2024"},
+		{"INBOX/child", dovecot, courier, "INBOX.child"},
+		{"INBOX/child/sub", dovecot, courier, "INBOX.child.sub"},
+		{"Inbox/child", dovecot, courier, "INBOX.child"},
+		{"v1.2/notas", dovecot, courier, "INBOX.v1_2.notas"},
+		{"INBOXES/x", dovecot, courier, "INBOX.INBOXES.x"},
+
+		// Ida e volta Courier -> Dovecot -> Courier
+		{"INBOX.child", dovecotDot, courier, "INBOX.child"},
+		{"INBOX.child", courier, courier, "INBOX.child"},
+		{"INBOX/child", dovecot, folderNamespace{Prefix: "INBOX/", Delim: '/'}, "INBOX/child"},
+
+		// Mesmo layout: nada muda
+		{"INBOX/child", dovecot, dovecot, "INBOX/child"},
+		{"a.b/c", dovecot, dovecot, "a.b/c"},
+
+		// Troca de delimitador com escape
+		{"INBOX/child", dovecot, dovecotDot, "INBOX.child"},
+		{"a.b/c", dovecot, dovecotDot, "a_b.c"},
+		{"a/b.c", dovecotDot, dovecot, "a_b/c"},
+
+		// Destino sem hierarquia
+		{"Projetos/2024", dovecot, flat, "Projetos_2024"},
+
+		// Pastas partilhadas (fora do prefixo pessoal da origem) não ganham prefixo
+		{"shared.equipa", courier, dovecot, "shared/equipa"},
+	}
+	for _, tt := range tests {
+		if got := translateFolderName(tt.name, tt.src, tt.dst, "_"); got != tt.want {
+			t.Errorf("translateFolderName(%q, %v -> %v) = %q, esperado %q", tt.name, tt.src, tt.dst, got, tt.want)
+		}
+	}
+}
+
+func TestTranslateSegments(t *testing.T) {
+	tests := []struct {
+		path     string
+		src, dst rune
+		escape   string
+		want     string
+	}{
+		{"a/b/c", '/', '.', "_", "a.b.c"},
+		{"a.b/c", '/', '.', "_", "a_b.c"},
+		{"a.b/c", '/', '.', "", "ab.c"},
+		{"a.b", '.', '.', "_", "a.b"},
+		{"a/b", '/', 0, "_", "a_b"},
+		{"a/b", 0, '.', "_", "a/b"},
+		{"a.b", 0, '.', "-", "a-b"},
+	}
+	for _, tt := range tests {
+		got := translateSegments(tt.path, folderNamespace{Delim: tt.src}, folderNamespace{Delim: tt.dst}, tt.escape)
+		if got != tt.want {
+			t.Errorf("translateSegments(%q, %q -> %q) = %q, esperado %q", tt.path, tt.src, tt.dst, got, tt.want)
+		}
+	}
+}
@@ -2,9 +2,9 @@ module imap-migrator
 
 go 1.22
 
-require github.com/emersion/go-imap/v2 v2.0.0-beta.7
-
 require (
-	github.com/emersion/go-message v0.18.1 // indirect
-	github.com/emersion/go-sasl v0.0.0-20231106173351-e73c9f7bad43 // indirect
+	github.com/emersion/go-imap/v2 v2.0.0-beta.7
+	github.com/emersion/go-sasl v0.0.0-20231106173351-e73c9f7bad43
 )
+
+require github.com/emersion/go-message v0.18.1 // indirect
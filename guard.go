@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sourceForbiddenCommands são os comandos IMAP que alteram a caixa de correio e que nunca
+// podem chegar à origem. SELECT também é recusado: a origem é sempre aberta com EXAMINE.
+var sourceForbiddenCommands = map[string]bool{
+	"APPEND":      true,
+	"STORE":       true,
+	"EXPUNGE":     true,
+	"COPY":        true,
+	"MOVE":        true,
+	"CREATE":      true,
+	"DELETE":      true,
+	"RENAME":      true,
+	"SUBSCRIBE":   true,
+	"UNSUBSCRIBE": true,
+	"SETACL":      true,
+	"DELETEACL":   true,
+	"SETMETADATA": true,
+	"SETQUOTA":    true,
+	"SELECT":      true,
+}
+
+// fetchSetsSeenRe encontra itens de FETCH que marcam a mensagem como lida (BODY[...] e
+// BINARY[...] sem PEEK, RFC822 e RFC822.TEXT).
+var fetchSetsSeenRe = regexp.MustCompile(`(^|[ (])(BODY\[|BINARY\[|RFC822(\.TEXT)?([ )]|$))`)
+
+// literalRe encontra um literal IMAP ({n}, {n+} ou ~{n}) no fim de uma linha de comando.
+var literalRe = regexp.MustCompile(`~?\{(\d+)\+?\}$`)
+
+// SourceWriteError indica que um comando capaz de alterar a origem foi bloqueado.
+type SourceWriteError struct {
+	Command string
+}
+
+func (e *SourceWriteError) Error() string {
+	return fmt.Sprintf("comando %s bloqueado: a origem só pode ser lida", e.Command)
+}
+
+// readOnlyConn envolve a ligação (já em claro, depois do TLS) à origem e inspeciona cada
+// comando enviado pelo cliente. Um comando que altere a caixa de correio fecha a ligação
+// antes de o servidor receber a linha completa, garantindo que a origem nunca é modificada.
+type readOnlyConn struct {
+	net.Conn
+	mu      sync.Mutex
+	line    []byte // linha de comando em curso
+	literal int64  // bytes de literal ainda por enviar, que não são comandos
+	command string // comando da linha atual, mantido nas continuações após um literal
+	blocked error
+}
+
+// newReadOnlyConn ativa a proteção de só leitura sobre uma ligação.
+func newReadOnlyConn(conn net.Conn) *readOnlyConn {
+	return &readOnlyConn{Conn: conn}
+}
+
+func (c *readOnlyConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.blocked != nil {
+		return 0, c.blocked
+	}
+	if err := c.inspect(p); err != nil {
+		c.blocked = err
+		log.Printf("ERRO: %v; ligação à origem fechada", err)
+		c.Conn.Close()
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+// inspect percorre os bytes enviados, saltando literais, e valida cada linha completa.
+func (c *readOnlyConn) inspect(p []byte) error {
+	for len(p) > 0 {
+		if c.literal > 0 {
+			n := min(int64(len(p)), c.literal)
+			c.literal -= n
+			p = p[n:]
+			continue
+		}
+
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			c.line = append(c.line, p...)
+			if err := c.check(false); err != nil {
+				return err
+			}
+			return nil
+		}
+		c.line = append(c.line, p[:i+1]...)
+		p = p[i+1:]
+		if err := c.check(true); err != nil {
+			return err
+		}
+		c.line = c.line[:0]
+	}
+	return nil
+}
+
+// check valida a linha em curso. Uma linha incompleta só é validada quando já tem o nome do
+// comando, para bloquear comandos proibidos mesmo que a linha chegue em várias escritas.
+func (c *readOnlyConn) check(complete bool) error {
+	line := strings.ToUpper(strings.TrimRight(string(c.line), "\r\n"))
+
+	continuation := c.command != ""
+	if !continuation {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || (!complete && len(fields) < 3 && !strings.HasSuffix(line, " ")) {
+			return nil
+		}
+		command := fields[1]
+		if command == "UID" {
+			if len(fields) < 3 {
+				return nil
+			}
+			command = fields[2]
+		}
+		if sourceForbiddenCommands[command] {
+			return &SourceWriteError{Command: command}
+		}
+		if complete {
+			c.command = command
+		}
+	}
+
+	if !complete {
+		return nil
+	}
+	if c.command == "FETCH" && fetchSetsSeenRe.MatchString(line) {
+		return &SourceWriteError{Command: "FETCH sem PEEK"}
+	}
+
+	// Um literal no fim da linha faz a linha seguinte continuar o mesmo comando
+	if m := literalRe.FindStringSubmatch(line); m != nil {
+		n, _ := strconv.ParseInt(m[1], 10, 64)
+		c.literal = n
+	} else {
+		c.command = ""
+	}
+	return nil
+}
+
+// greetingConn devolve uma saudação IMAP sintética antes dos dados da ligação, para que o
+// cliente possa ser criado sobre uma sessão cujo STARTTLS já foi negociado.
+type greetingConn struct {
+	net.Conn
+	pending []byte
+}
+
+func (c *greetingConn) Read(p []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// negotiateStartTLS lê a saudação, pede STARTTLS e faz o handshake, devolvendo a ligação cifrada.
+// As capacidades anunciadas antes do TLS são descartadas; o cliente volta a pedi-las.
+func negotiateStartTLS(conn net.Conn, config *tls.Config) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	r := bufio.NewReader(conn)
+
+	greeting, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler saudação: %w", err)
+	}
+	if strings.HasPrefix(strings.ToUpper(greeting), "* PREAUTH") {
+		return nil, fmt.Errorf("servidor enviou PREAUTH numa ligação sem cifra")
+	}
+	if !strings.HasPrefix(strings.ToUpper(greeting), "* OK") {
+		return nil, fmt.Errorf("saudação inesperada: %s", strings.TrimSpace(greeting))
+	}
+
+	if _, err := conn.Write([]byte("S0 STARTTLS\r\n")); err != nil {
+		return nil, fmt.Errorf("erro ao enviar STARTTLS: %w", err)
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler resposta ao STARTTLS: %w", err)
+		}
+		if !strings.HasPrefix(line, "S0 ") {
+			continue
+		}
+		if !strings.HasPrefix(strings.ToUpper(line), "S0 OK") {
+			return nil, fmt.Errorf("STARTTLS recusado: %s", strings.TrimSpace(line))
+		}
+		break
+	}
+	if r.Buffered() > 0 {
+		return nil, fmt.Errorf("dados inesperados antes do handshake STARTTLS")
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return &greetingConn{Conn: tlsConn, pending: []byte("* OK STARTTLS concluído\r\n")}, nil
+}
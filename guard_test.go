@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+func TestReadOnlyConnInspect(t *testing.T) {
+	tests := []struct {
+		name    string
+		writes  []string
+		blocked string // comando bloqueado; "" se tudo passa
+	}{
+		{name: "examine", writes: []string{"T1 EXAMINE INBOX\r\n"}},
+		{name: "fetch peek", writes: []string{"T1 UID FETCH 1:* (UID FLAGS BODY.PEEK[])\r\n"}},
+		{name: "fetch peek secção", writes: []string{"T1 FETCH 1 (BODY.PEEK[HEADER.FIELDS (MESSAGE-ID)])\r\n"}},
+		{name: "fetch body", writes: []string{"T1 FETCH 1 (UID BODY[])\r\n"}, blocked: "FETCH sem PEEK"},
+		{name: "fetch body secção", writes: []string{"T1 UID FETCH 1 BODY[TEXT]\r\n"}, blocked: "FETCH sem PEEK"},
+		{name: "fetch binary", writes: []string{"T1 UID FETCH 1 (UID BINARY[])\r\n"}, blocked: "FETCH sem PEEK"},
+		{name: "fetch binary secção", writes: []string{"T1 FETCH 1 binary[1]\r\n"}, blocked: "FETCH sem PEEK"},
+		{name: "fetch binary peek", writes: []string{"T1 FETCH 1 (BINARY.PEEK[1] BINARY.SIZE[1])\r\n"}},
+		{name: "fetch rfc822", writes: []string{"T1 FETCH 1 RFC822\r\n"}, blocked: "FETCH sem PEEK"},
+		{name: "fetch rfc822.size", writes: []string{"T1 FETCH 1 (RFC822.SIZE)\r\n"}},
+		{name: "select", writes: []string{"T1 SELECT INBOX\r\n"}, blocked: "SELECT"},
+		{name: "store", writes: []string{"T1 STORE 1 +FLAGS (\\Seen)\r\n"}, blocked: "STORE"},
+		{name: "uid store", writes: []string{"T1 UID STORE 1 +FLAGS (\\Seen)\r\n"}, blocked: "STORE"},
+		{name: "uid expunge", writes: []string{"T1 UID EXPUNGE 1\r\n"}, blocked: "EXPUNGE"},
+		{name: "minúsculas", writes: []string{"t1 uid store 1 flags ()\r\n"}, blocked: "STORE"},
+		{name: "append com literal", writes: []string{"T1 APPEND INBOX {3}\r\n", "abc\r\n"}, blocked: "APPEND"},
+		{
+			name:   "literal com texto de comando",
+			writes: []string{"T1 SEARCH HEADER Subject {24}\r\n", "T2 STORE 1 +FLAGS \\Seen\r\n", "\r\n", "T3 NOOP\r\n"},
+		},
+		{
+			name:    "comando após literal",
+			writes:  []string{"T1 SEARCH HEADER Subject {3+}\r\nabc\r\nT2 EXPUNGE\r\n"},
+			blocked: "EXPUNGE",
+		},
+		{name: "comando dividido", writes: []string{"T1 ST", "ORE 1 +FLAGS (\\Deleted)\r\n"}, blocked: "STORE"},
+		{name: "uid dividido", writes: []string{"T1 UID ", "STO", "RE 1 +FLAGS (\\Deleted)\r\n"}, blocked: "STORE"},
+		{name: "fetch dividido", writes: []string{"T1 FETCH 1 (BO", "DY[])\r\n"}, blocked: "FETCH sem PEEK"},
+		{name: "peek dividido", writes: []string{"T1 FETCH 1 (BODY", ".PEEK[])\r\n"}},
+		{name: "vários comandos", writes: []string{"T1 NOOP\r\nT2 COPY 1 Trash\r\n"}, blocked: "COPY"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newReadOnlyConn(nil)
+			var err error
+			for _, w := range tt.writes {
+				if err = c.inspect([]byte(w)); err != nil {
+					break
+				}
+			}
+
+			var writeErr *SourceWriteError
+			switch {
+			case tt.blocked == "" && err != nil:
+				t.Errorf("bloqueado sem motivo: %v", err)
+			case tt.blocked != "" && !errors.As(err, &writeErr):
+				t.Errorf("esperado bloqueio de %s, obtido %v", tt.blocked, err)
+			case tt.blocked != "" && writeErr.Command != tt.blocked:
+				t.Errorf("bloqueado %s, esperado %s", writeErr.Command, tt.blocked)
+			}
+		})
+	}
+}
+
+// Uma migração completa, em todos os modos, nunca envia à origem comandos que a alterem.
+func TestMigrateAccountNeverWritesToSource(t *testing.T) {
+	chdirTemp(t)
+
+	for _, security := range []string{SecurityPlain, SecurityStartTLS} {
+		t.Run(security, func(t *testing.T) {
+			var tlsConfig *tls.Config
+			if security == SecurityStartTLS {
+				tlsConfig = selfSignedTLSConfig(t)
+			}
+			source := newTestServer(t, nil, tlsConfig)
+			dest := newTestServer(t, nil, nil)
+			if err := source.User.Create("Projetos", nil); err != nil {
+				t.Fatal(err)
+			}
+			source.addMessage(t, "INBOX", testMessage("<1@test>", "lida"), imap.FlagSeen)
+			source.addMessage(t, "INBOX", testMessage("<2@test>", "nova"))
+			source.addMessage(t, "Projetos", testMessage("<3@test>", "marcada"), imap.FlagFlagged)
+
+			acc := MigrationAccount{
+				SourceEmail: "origem@test", SourceUser: "user", SourcePass: "pass", SourceHost: source.Host,
+				DestinationEmail: "destino@test", DestinationUser: "user", DestinationPass: "pass", DestinationHost: dest.Host,
+				SourceConnection: ConnectionOptions{
+					Port:     source.Port,
+					Security: security,
+					TLS:      TLSOptions{InsecureSkipVerify: true},
+				},
+				DestinationConnection: dest.options(),
+			}
+
+			stateDir := filepath.Join(t.TempDir(), "state")
+			for _, mode := range []string{ModeCopy, ModeSync, ModeFlags, ModeScan} {
+				if mode == ModeFlags {
+					clearDestinationFlags(t, dest)
+				}
+				config := loadTestConfig(t, fmt.Sprintf(`{"mode": %q, "state_dir": %q, "skip_duplicates": true}`, mode, stateDir))
+				group := groupAccountsByDestination([]MigrationAccount{acc}, config)[0]
+				if err := migrateAccount(acc, config, group); err != nil {
+					t.Fatalf("%s: %v", mode, err)
+				}
+				if mode == ModeCopy {
+					source.addMessage(t, "INBOX", testMessage("<4@test>", "depois da cópia"))
+				}
+			}
+
+			for _, command := range source.Commands() {
+				if command != "EXAMINE" {
+					t.Errorf("a origem recebeu %s; comandos: %v", command, source.Commands())
+				}
+			}
+			commands := strings.Join(dest.Commands(), " ")
+			if got := strings.Count(commands, "APPEND"); got != 4 {
+				t.Errorf("destino recebeu %d APPEND, esperado 4; comandos: %v", got, dest.Commands())
+			}
+			if !strings.Contains(commands, "STORE") {
+				t.Errorf("o modo flags não alinhou as flags no destino; comandos: %v", dest.Commands())
+			}
+		})
+	}
+}
+
+// clearDestinationFlags retira as flags das mensagens da INBOX do destino, para que o modo
+// flags tenha de as repor.
+func clearDestinationFlags(t *testing.T, dest *testServer) {
+	t.Helper()
+	client, err := connectClient(newEndpoint(dest.Host, "user", "pass", dest.options(), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Logout()
+	if _, err := client.Select("INBOX", nil).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	store := &imap.StoreFlags{Op: imap.StoreFlagsSet, Silent: true, Flags: []imap.Flag{}}
+	if err := client.Store(imap.SeqSetNum(1, 2, 3), store, nil).Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testMessage devolve uma mensagem RFC 5322 mínima.
+func testMessage(messageID, subject string) string {
+	return "From: a@test\r\nTo: b@test\r\nSubject: " + subject + "\r\nMessage-ID: " + messageID +
+		"\r\nDate: Mon, 02 Jan 2006 15:04:05 +0000\r\n\r\n" + subject + "\r\n"
+}
+
+// loadTestConfig carrega a configuração a partir de JSON, como o LoadConfig do programa.
+func loadTestConfig(t *testing.T, config string) MigrationConfig {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loaded
+}
+
+// chdirTemp muda para um diretório temporário, onde ficam os relatórios da migração.
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// selfSignedTLSConfig gera um certificado autoassinado para 127.0.0.1.
+func selfSignedTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
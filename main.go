@@ -3,18 +3,17 @@ package main
 import (
 	"bytes"
 	"encoding/csv"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net"
 	"os"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/imapclient"
 )
 
 // MigrationAccount armazena as informações para uma única migração.
@@ -28,36 +27,61 @@ type MigrationAccount struct {
 	DestinationUser  string
 	DestinationPass  string
 	DestinationHost  string
+
+	// Opções de ligação específicas da linha (sobrepõem a configuração)
+	SourceConnection      ConnectionOptions
+	DestinationConnection ConnectionOptions
+	Proxy                 string // sobrepõe o proxy global; "direct" desativa-o
+	DestinationPrefix     string // sobrepõe destination_prefix; "none" desativa-o
 }
 
 // FolderStats armazena estatísticas de uma pasta.
 type FolderStats struct {
 	Name            string
+	Destination     string
 	SourceMessages  uint32
 	CopiedMessages  int
 	FailedMessages  int
 	SkippedMessages int
+	UpdatedFlags    int
+	DuplicateSkips  map[string]int // onde a mensagem duplicada foi vista -> mensagens puladas
+}
+
+// addDuplicateSkip conta uma mensagem pulada por ser duplicada, pelo sítio onde foi vista.
+func (fs *FolderStats) addDuplicateSkip(origin string) {
+	fs.SkippedMessages++
+	if fs.DuplicateSkips == nil {
+		fs.DuplicateSkips = make(map[string]int)
+	}
+	fs.DuplicateSkips[origin]++
 }
 
 // MigrationReport armazena o relatório completo de uma migração.
 type MigrationReport struct {
-	SourceEmail      string
-	DestinationEmail string
-	StartTime        time.Time
-	EndTime          time.Time
-	Duration         time.Duration
-	Folders          []FolderStats
-	Errors           []string
-	Success          bool
-	TotalFolders     int
-	TotalSourceMsgs  uint32
-	TotalCopied      int
-	TotalFailed      int
-	TotalSkipped     int
+	SourceEmail           string
+	DestinationEmail      string
+	StartTime             time.Time
+	EndTime               time.Time
+	Duration              time.Duration
+	Folders               []FolderStats
+	Errors                []string
+	Success               bool
+	TotalFolders          int
+	TotalSourceMsgs       uint32
+	TotalCopied           int
+	TotalFailed           int
+	TotalSkipped          int
+	TotalFlagUpdates      int
+	FolderPlan            []folderPlanEntry // preenchido no modo plan
+	FolderCollisions      []string          // pastas de origem com o mesmo destino e a decisão tomada
+	DuplicateScope        string            // âmbito da detecção de duplicados, se ativa
+	SourceDuplicates      []string          // mensagens repetidas em várias pastas da origem, por combinação de pastas
+	SourceDuplicatePolicy string            // source_duplicates aplicada, se a origem foi analisada
 }
 
-// readCSV lê o ficheiro de contas e retorna uma lista de MigrationAccount.
-func readCSV(filePath string) ([]MigrationAccount, error) {
+// readCSV lê o ficheiro de contas e retorna uma lista de MigrationAccount. As opções de
+// ligação de cada linha são validadas já combinadas com as da configuração.
+func readCSV(filePath string, config MigrationConfig) ([]MigrationAccount, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("erro ao abrir o ficheiro CSV: %w", err)
@@ -74,6 +98,18 @@ func readCSV(filePath string) ([]MigrationAccount, error) {
 		return nil, fmt.Errorf("ficheiro CSV está vazio")
 	}
 
+	// Colunas opcionais são identificadas pelo nome no cabeçalho
+	header := make(map[string]int)
+	for idx, name := range records[0] {
+		header[strings.ToLower(strings.TrimSpace(name))] = idx
+	}
+	column := func(record []string, name string) string {
+		if idx, ok := header[name]; ok && idx < len(record) {
+			return strings.TrimSpace(record[idx])
+		}
+		return ""
+	}
+
 	var accounts []MigrationAccount
 	for i, record := range records {
 		if i == 0 {
@@ -97,70 +133,62 @@ func readCSV(filePath string) ([]MigrationAccount, error) {
 			DestinationHost:  strings.TrimSpace(record[7]),
 		}
 
-		accounts = append(accounts, acc)
-	}
-
-	return accounts, nil
-}
-
-// connectClient estabelece conexão TLS com um servidor IMAP.
-func connectClient(host, user, pass string) (*imapclient.Client, error) {
-	addr := net.JoinHostPort(host, "993")
-	c, err := imapclient.DialTLS(addr, nil)
-	if err != nil {
-		return nil, fmt.Errorf("falha ao conectar via TLS: %w", err)
-	}
-
-	if err := c.Login(user, pass).Wait(); err != nil {
-		c.Close()
-		return nil, fmt.Errorf("falha ao fazer login: %w", err)
-	}
+		var err error
+		acc.SourceConnection, err = parseConnectionColumns(func(name string) string { return column(record, name) }, "origem")
+		if err != nil {
+			log.Printf("AVISO: Linha %d tem opções de ligação de origem inválidas (%v), ignorando.", i+1, err)
+			continue
+		}
+		acc.DestinationConnection, err = parseConnectionColumns(func(name string) string { return column(record, name) }, "destino")
+		if err != nil {
+			log.Printf("AVISO: Linha %d tem opções de ligação de destino inválidas (%v), ignorando.", i+1, err)
+			continue
+		}
+		if err := mergeConnectionOptions(config.SourceConnection, acc.SourceConnection).Validate(); err != nil {
+			log.Printf("AVISO: Linha %d tem opções de ligação de origem inválidas (%v), ignorando.", i+1, err)
+			continue
+		}
+		if err := mergeConnectionOptions(config.DestinationConnection, acc.DestinationConnection).Validate(); err != nil {
+			log.Printf("AVISO: Linha %d tem opções de ligação de destino inválidas (%v), ignorando.", i+1, err)
+			continue
+		}
+		acc.Proxy = column(record, "proxy")
+		if _, err := parseProxyURL(acc.Proxy); err != nil {
+			log.Printf("AVISO: Linha %d tem proxy inválido (%v), ignorando.", i+1, err)
+			continue
+		}
 
-	return c, nil
-}
+		acc.DestinationPrefix = column(record, "prefixo_destino")
 
-// testConnection testa a conexão com um servidor IMAP.
-func testConnection(host, user, pass string) error {
-	client, err := connectClient(host, user, pass)
-	if err != nil {
-		return err
+		accounts = append(accounts, acc)
 	}
-	defer client.Logout()
-	return nil
-}
 
-// isConnectionClosed verifica se um erro indica conexão fechada.
-func isConnectionClosed(err error) bool {
-	if err == nil {
-		return false
-	}
-	errStr := err.Error()
-	return strings.Contains(errStr, "closed network connection") ||
-		strings.Contains(errStr, "EOF") ||
-		strings.Contains(errStr, "broken pipe") ||
-		strings.Contains(errStr, "connection reset")
+	return accounts, nil
 }
 
-// reconnectIfNeeded reconecta se o erro indicar conexão fechada.
-func reconnectIfNeeded(client **imapclient.Client, host, user, pass string, err error) error {
-	if !isConnectionClosed(err) {
-		return err
+// parseConnectionColumns lê as colunas opcionais de ligação de um dos lados ("origem" ou "destino").
+func parseConnectionColumns(column func(name string) string, side string) (ConnectionOptions, error) {
+	opts := ConnectionOptions{
+		Security: strings.ToLower(column("seguranca_" + side)),
+		Auth:     strings.ToLower(column("autenticacao_" + side)),
+		OAuth: OAuthOptions{
+			TokenFile:    column("ficheiro_token_" + side),
+			RefreshToken: column("refresh_token_" + side),
+		},
+		MasterUser: column("master_" + side),
+		MasterPass: column("senha_master_" + side),
 	}
-
-	log.Printf("Conexão fechada detectada. Tentando reconectar a %s...", host)
-
-	if *client != nil {
-		(*client).Close()
+	if port := column("porta_" + side); port != "" {
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return ConnectionOptions{}, fmt.Errorf("porta inválida '%s'", port)
+		}
+		opts.Port = p
 	}
-
-	newClient, err := connectClient(host, user, pass)
-	if err != nil {
-		return fmt.Errorf("falha ao reconectar: %w", err)
+	if err := opts.validateFields(); err != nil {
+		return ConnectionOptions{}, err
 	}
-
-	*client = newClient
-	log.Printf("Reconexão bem-sucedida a %s", host)
-	return nil
+	return opts, nil
 }
 
 // filterValidFlags remove flags que podem causar problemas.
@@ -174,8 +202,9 @@ func filterValidFlags(flags []imap.Flag) []imap.Flag {
 	return validFlags
 }
 
-// migrateAccount executa a migração para uma única conta.
-func migrateAccount(acc MigrationAccount, config MigrationConfig) error {
+// migrateAccount executa a migração para uma única conta. group é o conjunto de contas com o
+// mesmo destino, que partilham a detecção de duplicados.
+func migrateAccount(acc MigrationAccount, config MigrationConfig, group *destinationGroup) error {
 	log.Printf("[ÍNÍCIO MIGRAÇÃO] %s -> %s", acc.SourceEmail, acc.DestinationEmail)
 
 	// Inicializar relatório
@@ -193,72 +222,173 @@ func migrateAccount(acc MigrationAccount, config MigrationConfig) error {
 		if err := saveReport(report); err != nil {
 			log.Printf("[%s] AVISO: não foi possível guardar o relatório: %v", acc.SourceEmail, err)
 		}
+		group.addReport(report)
 	}()
 
-	sourceClient, err := connectClient(acc.SourceHost, acc.SourceUser, acc.SourcePass)
+	sourceEP := acc.sourceEndpoint(config)
+	destEP := acc.destinationEndpoint(config)
+
+	sourceClient, err := connectClient(sourceEP)
 	if err != nil {
 		return fmt.Errorf("erro ao conectar à origem: %w", err)
 	}
 	defer sourceClient.Logout()
 
-	destClient, err := connectClient(acc.DestinationHost, acc.DestinationUser, acc.DestinationPass)
+	destClient, err := connectClient(destEP)
 	if err != nil {
 		return fmt.Errorf("erro ao conectar ao destino: %w", err)
 	}
 	defer destClient.Logout()
 
-	mailboxes, err := sourceClient.List("", "*", nil).Collect()
+	mailboxes, err := sourceClient.List("", "*", listOptionsFor(sourceClient)).Collect()
 	if err != nil {
 		return fmt.Errorf("falha ao listar pastas na origem: %w", err)
 	}
 
 	log.Printf("[%s] Encontradas %d pastas para migrar.", acc.SourceEmail, len(mailboxes))
 
-	// Inicializar rastreador de duplicados se necessário
-	var dupTracker *DuplicateTracker
-	if config.SkipDuplicates {
-		dupTracker = NewDuplicateTracker()
-		log.Printf("[%s] Detecção de duplicados ativada", acc.SourceEmail)
+	destMailboxes, err := destClient.List("", "*", listOptionsFor(destClient)).Collect()
+	if err != nil {
+		log.Printf("[%s] AVISO: não foi possível listar pastas no destino: %v", acc.DestinationEmail, err)
 	}
 
-	for _, mb := range mailboxes {
-		if slices.Contains(mb.Attrs, imap.MailboxAttrNoSelect) {
-			log.Printf("[%s] Ignorando pasta não selecionável: %s", acc.SourceEmail, mb.Mailbox)
-			continue
+	// Namespace pessoal e delimitador de cada lado, para traduzir os caminhos das pastas
+	sourceNS := discoverNamespace(sourceClient, mailboxes)
+	destNS := discoverNamespace(destClient, destMailboxes)
+	log.Printf("[%s] Namespace na origem: %s; no destino: %s", acc.SourceEmail, sourceNS, destNS)
+
+	// Descobrir as pastas de sistema do destino (SPECIAL-USE ou aliases de system_folders)
+	destRoles := discoverRoleFolders(destMailboxes, config.SystemFolders)
+	for _, role := range folderRoles {
+		if name, ok := destRoles[role]; ok {
+			log.Printf("[%s] Pasta de sistema '%s' no destino: %s", acc.DestinationEmail, role, name)
 		}
+	}
 
-		folderName := mb.Mailbox
+	// Calcular o destino de todas as pastas antes de copiar
+	prefix := acc.destinationPrefix(config, destNS)
+	if prefix != "" {
+		log.Printf("[%s] Todas as pastas serão colocadas debaixo de '%s' no destino", acc.SourceEmail, prefix)
+	}
+	plan := buildFolderPlan(acc.SourceEmail, mailboxes, config, sourceNS, destNS, destRoles, destClient.Caps().Has(imap.CapCreateSpecialUse), prefix)
 
-		// Filtro de pastas
-		if !config.ShouldIncludeFolder(folderName) {
-			log.Printf("[%s] Pasta '%s' excluída por filtro de configuração", acc.SourceEmail, folderName)
-			continue
+	// Detetar pastas de origem que iriam parar à mesma pasta do destino
+	collisions, err := resolveFolderCollisions(plan, destMailboxes, config.FolderCollisionPolicy, config.FolderNamesCaseSensitive)
+	for _, c := range collisions {
+		report.FolderCollisions = append(report.FolderCollisions, c.Report())
+		log.Printf("[%s] AVISO: colisão de pastas no destino: %s", acc.SourceEmail, c)
+	}
+	if config.Mode == ModePlan || config.DryRun {
+		log.Printf("[%s] Plano de pastas (destino <- origem) para %s:\n%s", acc.SourceEmail, acc.DestinationEmail, formatFolderPlan(plan, destNS))
+	}
+	if config.Mode == ModePlan {
+		report.FolderPlan = plan
+	}
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return err
+	}
+	if config.Mode == ModePlan {
+		report.Success = true
+		return nil
+	}
+
+	// Analisar mensagens repetidas em várias pastas da origem (modo scan ou política once)
+	var sourceDups *sourceDuplicateScan
+	if config.Mode == ModeScan || (config.SourceDuplicates == SourceDuplicatesOnce && config.Mode != ModeFlags) {
+		log.Printf("[%s] Analisando duplicados na origem (chave: %s)...", acc.SourceEmail, config.DuplicateKey)
+		ranks := folderPriority(plan, mailboxes, config.priorityRules)
+		sourceDups, err = scanSourceDuplicates(sourceClient, acc.SourceEmail, plan, ranks, config.DuplicateKey)
+		if err != nil {
+			if reconnectErr := reconnectIfNeeded(&sourceClient, sourceEP, err); reconnectErr == nil {
+				sourceDups, err = scanSourceDuplicates(sourceClient, acc.SourceEmail, plan, ranks, config.DuplicateKey)
+			}
+		}
+		if err != nil {
+			errMsg := fmt.Sprintf("Falha na análise de duplicados na origem: %v", err)
+			report.Errors = append(report.Errors, errMsg)
+			if config.Mode == ModeScan {
+				return fmt.Errorf("falha na análise de duplicados na origem: %w", err)
+			}
+			log.Printf("[%s] AVISO: %s; todas as mensagens serão copiadas", acc.SourceEmail, errMsg)
+			sourceDups = nil
+		} else {
+			report.SourceDuplicatePolicy = config.SourceDuplicates
+			log.Printf("[%s] Duplicados na origem: %d mensagens analisadas, %d repetidas em mais de uma pasta", acc.SourceEmail, sourceDups.Messages, len(sourceDups.Groups))
+			for _, d := range sourceDups.Summary() {
+				report.SourceDuplicates = append(report.SourceDuplicates, d.Report())
+				log.Printf("[%s]   %s", acc.SourceEmail, d)
+			}
+			// As pastas prioritárias são copiadas primeiro: as outras ocorrências só são puladas
+			// quando a preferida já está no destino
+			plan = sortByPriority(plan, ranks)
 		}
+	}
+	if config.Mode == ModeScan {
+		report.Success = true
+		return nil
+	}
+
+	// Abrir checkpoint para retomar migrações interrompidas (só leitura em dry-run)
+	var checkpoint *CheckpointStore
+	if config.StateDir != "" {
+		checkpoint, err = OpenCheckpointStore(config.StateDir, acc.SourceEmail, acc.DestinationEmail, config.DryRun)
+		if err != nil {
+			return fmt.Errorf("erro ao abrir checkpoint: %w", err)
+		}
+		defer checkpoint.Close()
+	}
+
+	// A origem é aberta com EXAMINE (só leitura), pedindo HIGHESTMODSEQ quando o servidor suporta CONDSTORE
+	sourceSelectOptions := &imap.SelectOptions{
+		ReadOnly:  true,
+		CondStore: sourceClient.Caps().Has(imap.CapCondStore),
+	}
+
+	// Inicializar rastreador de duplicados se necessário (partilhado pelas contas com o mesmo destino)
+	var dupTracker *DuplicateTracker
+	if config.SkipDuplicates {
+		dupTracker = group.DuplicateTracker(config)
+		report.DuplicateScope = config.DuplicateScope
+		if group.IsMerge() {
+			log.Printf("[%s] Detecção de duplicados ativada (âmbito: %s, chave: %s), partilhada com as outras contas de '%s'", acc.SourceEmail, config.DuplicateScope, config.DuplicateKey, group.Destination)
+		} else {
+			log.Printf("[%s] Detecção de duplicados ativada (âmbito: %s, chave: %s)", acc.SourceEmail, config.DuplicateScope, config.DuplicateKey)
+		}
+		if dupTracker.UsesContent() && config.DryRun {
+			log.Printf("[%s] [DRY-RUN] Duplicados por conteúdo não são avaliados: exigem descarregar os corpos", acc.SourceEmail)
+		}
+	}
+
+	for _, entry := range plan {
+		folderName := entry.Source
+		destFolderName := entry.Destination
 
 		log.Printf("[%s] Processando pasta: %s", acc.SourceEmail, folderName)
 
 		folderStats := FolderStats{
 			Name:            folderName,
+			Destination:     destFolderName,
 			SourceMessages:  0,
 			CopiedMessages:  0,
 			FailedMessages:  0,
 			SkippedMessages: 0,
 		}
 
-		// Mapeamento e flatten de nome
-		destFolderName := config.GetMappedFolderName(folderName)
-		destFolderName = config.FlattenFolderName(destFolderName)
-
+		if entry.Role != "" {
+			log.Printf("[%s] Pasta '%s' reconhecida como pasta de sistema '%s'", acc.SourceEmail, folderName, entry.Role)
+		}
 		if destFolderName != folderName {
 			log.Printf("[%s] Pasta '%s' será criada como '%s' no destino", acc.SourceEmail, folderName, destFolderName)
 		}
 
-		// Criar pasta no destino
-		if !config.DryRun {
-			err := destClient.Create(destFolderName, nil).Wait()
+		// Criar pasta no destino. Em modo flags só se tocam pastas já migradas.
+		creating := config.Mode != ModeFlags && !entry.Exists
+		if creating && !config.DryRun {
+			err := createFolder(destClient, destFolderName, entry.CreateOptions)
 			if err != nil {
-				if reconnectErr := reconnectIfNeeded(&destClient, acc.DestinationHost, acc.DestinationUser, acc.DestinationPass, err); reconnectErr == nil {
-					err = destClient.Create(destFolderName, nil).Wait()
+				if reconnectErr := reconnectIfNeeded(&destClient, destEP, err); reconnectErr == nil {
+					err = createFolder(destClient, destFolderName, entry.CreateOptions)
 					if err != nil {
 						log.Printf("[%s] Aviso: não foi possível criar a pasta '%s' no destino (pode já existir): %v", acc.DestinationEmail, destFolderName, err)
 					}
@@ -266,23 +396,15 @@ func migrateAccount(acc MigrationAccount, config MigrationConfig) error {
 					log.Printf("[%s] Aviso: não foi possível criar a pasta '%s' no destino (pode já existir): %v", acc.DestinationEmail, destFolderName, err)
 				}
 			}
-
-			// Construir índice de duplicados se necessário
-			if config.SkipDuplicates {
-				log.Printf("[%s] Construindo índice de mensagens existentes na pasta '%s'...", acc.DestinationEmail, destFolderName)
-				if err := dupTracker.BuildExistingMessagesIndex(destClient, destFolderName); err != nil {
-					log.Printf("[%s] AVISO: não foi possível construir índice de duplicados para '%s': %v", acc.DestinationEmail, destFolderName, err)
-				}
-			}
-		} else {
+		} else if creating {
 			log.Printf("[%s] [DRY-RUN] Pasta '%s' seria criada como '%s'", acc.SourceEmail, folderName, destFolderName)
 		}
 
 		// Selecionar pasta de origem
-		sourceData, err := sourceClient.Select(folderName, nil).Wait()
+		sourceData, err := sourceClient.Select(folderName, sourceSelectOptions).Wait()
 		if err != nil {
-			if reconnectErr := reconnectIfNeeded(&sourceClient, acc.SourceHost, acc.SourceUser, acc.SourcePass, err); reconnectErr == nil {
-				sourceData, err = sourceClient.Select(folderName, nil).Wait()
+			if reconnectErr := reconnectIfNeeded(&sourceClient, sourceEP, err); reconnectErr == nil {
+				sourceData, err = sourceClient.Select(folderName, sourceSelectOptions).Wait()
 				if err != nil {
 					log.Printf("[%s] ERRO: não foi possível selecionar a pasta '%s' na origem: %v", acc.SourceEmail, folderName, err)
 					continue
@@ -298,32 +420,83 @@ func migrateAccount(acc MigrationAccount, config MigrationConfig) error {
 
 		folderStats.SourceMessages = sourceData.NumMessages
 
+		if checkpoint != nil {
+			changed, err := checkpoint.BeginFolder(folderName, sourceData.UIDValidity)
+			if err != nil {
+				return fmt.Errorf("erro ao atualizar checkpoint: %w", err)
+			}
+			if changed {
+				errMsg := fmt.Sprintf("UIDVALIDITY da pasta '%s' mudou para %d desde a execução anterior; checkpoint descartado e pasta copiada de novo", folderName, sourceData.UIDValidity)
+				report.Errors = append(report.Errors, errMsg)
+				log.Printf("[%s] AVISO: %s", acc.SourceEmail, errMsg)
+			} else if n := checkpoint.MigratedCount(folderName); n > 0 {
+				log.Printf("[%s] Pasta '%s': %d mensagens já migradas em execuções anteriores serão ignoradas", acc.SourceEmail, folderName, n)
+			}
+		}
+
+		// Em modo flags, alinhar as flags das mensagens já copiadas sem copiar corpos
+		if config.Mode == ModeFlags {
+			updated, err := syncMigratedFlags(sourceClient, destClient, checkpoint, folderName, destFolderName, config.DryRun)
+			if err != nil {
+				// Reconectar a ligação que falhou; a pasta de origem tem de ser reaberta
+				var sourceErr *sourceSideError
+				if errors.As(err, &sourceErr) {
+					if reconnectErr := reconnectIfNeeded(&sourceClient, sourceEP, err); reconnectErr == nil {
+						if _, err = sourceClient.Select(folderName, sourceSelectOptions).Wait(); err == nil {
+							updated, err = syncMigratedFlags(sourceClient, destClient, checkpoint, folderName, destFolderName, config.DryRun)
+						}
+					}
+				} else if reconnectErr := reconnectIfNeeded(&destClient, destEP, err); reconnectErr == nil {
+					updated, err = syncMigratedFlags(sourceClient, destClient, checkpoint, folderName, destFolderName, config.DryRun)
+				}
+			}
+			if err != nil {
+				errMsg := fmt.Sprintf("Falha ao sincronizar flags da pasta '%s': %v", folderName, err)
+				report.Errors = append(report.Errors, errMsg)
+				log.Printf("[%s] ERRO: %s", acc.SourceEmail, errMsg)
+			} else if config.DryRun {
+				log.Printf("[%s] [DRY-RUN] Pasta '%s': flags seriam atualizadas em %d mensagens no destino", acc.SourceEmail, folderName, updated)
+			} else {
+				folderStats.UpdatedFlags = updated
+				log.Printf("[%s] Pasta '%s': flags atualizadas em %d mensagens no destino", acc.SourceEmail, folderName, updated)
+			}
+			report.Folders = append(report.Folders, folderStats)
+			continue
+		}
+
 		if sourceData.NumMessages == 0 {
 			log.Printf("[%s] Pasta '%s' está vazia, passando para a próxima.", acc.SourceEmail, folderName)
 			report.Folders = append(report.Folders, folderStats)
 			continue
 		}
 
-		// Buscar mensagens
-		uidSet := imap.UIDSet{}
-		uidSet.AddRange(1, sourceData.UIDNext-1)
-		fetchOptions := &imap.FetchOptions{
-			BodySection: []*imap.FetchItemBodySection{{}},
-			Flags:       true,
-			Envelope:    true,
+		// Obter metadados de todas as mensagens, sem corpos.
+		// Em modo sync, só as posteriores à última marca de sincronização.
+		uidSet := folderUIDSet(sourceData)
+		var highUID imap.UID
+		var highModSeq uint64
+		if checkpoint != nil {
+			highUID, highModSeq = checkpoint.HighWaterMark(folderName)
+		}
+		syncing := config.Mode == ModeSync && highUID > 0
+		if syncing {
+			uidSet = imap.UIDSet{}
+			uidSet.AddRange(highUID+1, 0)
+			log.Printf("[%s] Pasta '%s': sincronização incremental a partir do UID %d (HIGHESTMODSEQ guardado: %d, atual: %d)",
+				acc.SourceEmail, folderName, highUID+1, highModSeq, sourceData.HighestModSeq)
 		}
 
-		log.Printf("[%s] Fazendo fetch de mensagens da pasta '%s' usando UIDs...", acc.SourceEmail, folderName)
+		log.Printf("[%s] Obtendo metadados das mensagens da pasta '%s'...", acc.SourceEmail, folderName)
 
-		messages, err := sourceClient.Fetch(uidSet, fetchOptions).Collect()
+		metas, err := fetchMessageMetadata(sourceClient, uidSet, config.DateSource)
 		if err != nil {
-			if reconnectErr := reconnectIfNeeded(&sourceClient, acc.SourceHost, acc.SourceUser, acc.SourcePass, err); reconnectErr == nil {
-				sourceData, err = sourceClient.Select(folderName, nil).Wait()
+			if reconnectErr := reconnectIfNeeded(&sourceClient, sourceEP, err); reconnectErr == nil {
+				sourceData, err = sourceClient.Select(folderName, sourceSelectOptions).Wait()
 				if err != nil {
 					log.Printf("[%s] ERRO: não foi possível reselecionar pasta após reconexão: %v", acc.SourceEmail, err)
 					continue
 				}
-				messages, err = sourceClient.Fetch(uidSet, fetchOptions).Collect()
+				metas, err = fetchMessageMetadata(sourceClient, uidSet, config.DateSource)
 				if err != nil {
 					log.Printf("[%s] ERRO: falha ao obter mensagens após reconexão: %v", acc.SourceEmail, err)
 					continue
@@ -334,14 +507,51 @@ func migrateAccount(acc MigrationAccount, config MigrationConfig) error {
 			}
 		}
 
-		log.Printf("[%s] Pasta '%s' tem %d mensagens para processar.", acc.SourceEmail, folderName, len(messages))
+		if syncing {
+			// "n:*" devolve sempre a última mensagem, mesmo com UID < n
+			metas = slices.DeleteFunc(metas, func(m messageMeta) bool { return m.UID <= highUID })
+			folderStats.SkippedMessages += int(sourceData.NumMessages) - len(metas)
+		}
+
+		// As mensagens já migradas em execuções anteriores são retiradas antes de contactar o
+		// destino; a marca de sincronização considera todos os UIDs vistos
+		seenUID := highUID
+		for _, meta := range metas {
+			seenUID = max(seenUID, meta.UID)
+		}
+		if checkpoint != nil {
+			before := len(metas)
+			metas = slices.DeleteFunc(metas, func(m messageMeta) bool { return checkpoint.IsMigrated(folderName, m.UID) })
+			folderStats.SkippedMessages += before - len(metas)
+		}
+
+		log.Printf("[%s] Pasta '%s' tem %d mensagens para processar.", acc.SourceEmail, folderName, len(metas))
+
+		// Verificar que mensagens já existem no destino: indexando a pasta, ou procurando só os
+		// Message-IDs das mensagens a copiar quando a pasta é grande e estas são poucas
+		if config.SkipDuplicates && !config.DryRun && len(metas) > 0 {
+			var candidates []string
+			for _, meta := range metas {
+				if id := meta.MessageID(); id != "" {
+					candidates = append(candidates, id)
+				}
+			}
+			log.Printf("[%s] Verificando mensagens existentes na pasta '%s'...", acc.DestinationEmail, destFolderName)
+			stats, err := dupTracker.BuildExistingMessagesIndex(destClient, acc.SourceEmail, destFolderName, candidates)
+			if err != nil {
+				log.Printf("[%s] AVISO: não foi possível construir índice de duplicados para '%s': %v", acc.DestinationEmail, destFolderName, err)
+			} else {
+				log.Printf("[%s] Duplicados na pasta '%s': %s", acc.DestinationEmail, destFolderName, stats)
+			}
+		}
 
-		// Selecionar pasta de destino
-		if !config.DryRun {
-			_, err = destClient.Select(destFolderName, nil).Wait()
+		// Selecionar pasta de destino, se houver mensagens a copiar ou flags a replicar
+		var destData *imap.SelectData
+		if !config.DryRun && (len(metas) > 0 || syncing) {
+			destData, err = destClient.Select(destFolderName, nil).Wait()
 			if err != nil {
-				if reconnectErr := reconnectIfNeeded(&destClient, acc.DestinationHost, acc.DestinationUser, acc.DestinationPass, err); reconnectErr == nil {
-					_, err = destClient.Select(destFolderName, nil).Wait()
+				if reconnectErr := reconnectIfNeeded(&destClient, destEP, err); reconnectErr == nil {
+					destData, err = destClient.Select(destFolderName, nil).Wait()
 					if err != nil {
 						log.Printf("[%s] ERRO: não foi possível selecionar a pasta '%s' no destino após reconexão: %v", acc.DestinationEmail, destFolderName, err)
 						continue
@@ -353,106 +563,226 @@ func migrateAccount(acc MigrationAccount, config MigrationConfig) error {
 			}
 		}
 
-		copiedCount := 0
-		for i, msg := range messages {
-			// Verificar corpo da mensagem
-			if len(msg.BodySection) == 0 {
-				log.Printf("[%s] AVISO: mensagem %d/%d da pasta '%s' tem corpo vazio, pulando.", acc.SourceEmail, i+1, len(messages), folderName)
-				folderStats.SkippedMessages++
+		// Em modo sync, replicar no destino as flags alteradas na origem desde a última execução
+		flagsSynced := true
+		if syncing && !config.DryRun {
+			sinceModSeq := uint64(0)
+			if sourceData.HighestModSeq > 0 {
+				sinceModSeq = highModSeq
+			}
+			changes, err := fetchFlagChanges(sourceClient, checkpoint, folderName, highUID, sinceModSeq)
+			if err == nil && len(changes) > 0 {
+				var updated, unmapped int
+				updated, unmapped, err = applyFlagChanges(destClient, checkpoint, folderName, destData.UIDValidity, changes)
+				folderStats.UpdatedFlags += updated
+				log.Printf("[%s] Pasta '%s': flags atualizadas em %d mensagens no destino", acc.SourceEmail, folderName, updated)
+				if unmapped > 0 {
+					log.Printf("[%s] AVISO: %d mensagens da pasta '%s' mudaram de flags mas não têm UID de destino conhecido (servidor sem UIDPLUS?)", acc.SourceEmail, unmapped, folderName)
+				}
+			}
+			if err != nil {
+				flagsSynced = false
+				errMsg := fmt.Sprintf("Falha ao sincronizar flags da pasta '%s': %v", folderName, err)
+				report.Errors = append(report.Errors, errMsg)
+				log.Printf("[%s] ERRO: %s", acc.SourceEmail, errMsg)
+			}
+		}
+
+		// Os logs numeram as mensagens pela posição entre as que restam processar (em sync,
+		// só as novas), e não pelo número de sequência na pasta
+		total := len(metas)
+		position := make(map[imap.UID]int, total)
+		for n, meta := range metas {
+			position[meta.UID] = n + 1
+		}
+
+		// skipDuplicate pula a mensagem se a chave já estiver no destino no âmbito configurado.
+		// A chave só é registada como copiada depois do APPEND (MarkAsCopied), para que uma
+		// falha não faça pular as outras cópias da mensagem.
+		skipDuplicate := func(meta messageMeta, total int, key string) bool {
+			if origin, dup := dupTracker.IsDuplicate(acc.SourceEmail, destFolderName, key); dup {
+				log.Printf("[%s] Mensagem %d/%d pulada: duplicada no âmbito '%s', %s (chave: %s)", acc.SourceEmail, position[meta.UID], total, dupTracker.Scope(), origin, key)
+				folderStats.addDuplicateSkip(origin.Report())
+				sourceDups.MarkCopied(folderName, meta.UID)
+				return true
+			}
+			return false
+		}
+
+		// Aplicar filtros e detecção de duplicados sobre os metadados
+		var selected []messageMeta
+		dupKeys := make(map[imap.UID]string) // chave de duplicados de cada mensagem selecionada
+		for _, meta := range metas {
+			if kept, ok := sourceDups.KeptIn(folderName, meta.UID, checkpoint); ok {
+				log.Printf("[%s] Mensagem %d/%d pulada: também está na pasta '%s' da origem, onde é copiada", acc.SourceEmail, position[meta.UID], total, kept)
+				folderStats.addDuplicateSkip(fmt.Sprintf("copied from source folder '%s' (source_duplicates = once)", kept))
 				continue
 			}
-			bodyBytes := msg.BodySection[0].Bytes
 
-			// Filtro de tamanho
-			if shouldInclude, reason := config.ShouldIncludeMessage(msg.Envelope.Date, len(bodyBytes)); !shouldInclude {
-				log.Printf("[%s] Mensagem %d/%d pulada: %s", acc.SourceEmail, i+1, len(messages), reason)
+			if shouldInclude, reason := config.ShouldIncludeMessage(meta.FilterDate(config.DateSource), int(meta.Size)); !shouldInclude {
+				log.Printf("[%s] Mensagem %d/%d pulada: %s", acc.SourceEmail, position[meta.UID], total, reason)
 				folderStats.SkippedMessages++
 				continue
 			}
 
-			// Verificar duplicados
-			if config.SkipDuplicates {
-				messageID := msg.Envelope.MessageID
+			// Com chave por conteúdo, a verificação é feita depois de obter o corpo
+			if config.SkipDuplicates && !dupTracker.UsesContent() {
+				messageID := meta.MessageID()
 				if messageID == "" {
-					messageID = GenerateMessageHash(msg.Envelope, len(bodyBytes))
+					messageID = GenerateMessageHash(meta.Envelope, int(meta.Size))
 				}
-				if dupTracker.IsDuplicate(messageID) {
-					log.Printf("[%s] Mensagem %d/%d pulada: duplicada (Message-ID: %s)", acc.SourceEmail, i+1, len(messages), messageID)
-					folderStats.SkippedMessages++
+				if skipDuplicate(meta, total, messageID) {
 					continue
 				}
-				dupTracker.MarkAsCopied(messageID)
+				dupKeys[meta.UID] = messageID
 			}
 
-			validFlags := filterValidFlags(msg.Flags)
+			sourceDups.MarkQueued(folderName, meta.UID)
+			selected = append(selected, meta)
+		}
 
-			log.Printf("[%s] Copiando mensagem %d/%d da pasta '%s' (tamanho: %d bytes)...", acc.SourceEmail, i+1, len(messages), folderName, len(bodyBytes))
+		// Copiar em lotes de UIDs, limitando a memória usada pelos corpos
+		batches := planBatches(selected, config.FetchBatchSize, config.MemoryBudgetBytes())
+		if len(batches) > 0 {
+			log.Printf("[%s] Pasta '%s': %d mensagens a copiar em %d lotes", acc.SourceEmail, folderName, len(selected), len(batches))
+		}
 
-			if config.DryRun {
-				log.Printf("[%s] [DRY-RUN] Mensagem %d/%d seria copiada", acc.SourceEmail, i+1, len(messages))
-				folderStats.CopiedMessages++
-				copiedCount++
-				continue
+		copiedCount := 0
+		var firstFailedUID imap.UID
+		for _, batch := range batches {
+			var bodies map[imap.UID][]byte
+			if !config.DryRun {
+				bodies, err = fetchBatchBodies(sourceClient, batch)
+				if err != nil {
+					if reconnectErr := reconnectIfNeeded(&sourceClient, sourceEP, err); reconnectErr == nil {
+						if _, err = sourceClient.Select(folderName, sourceSelectOptions).Wait(); err == nil {
+							bodies, err = fetchBatchBodies(sourceClient, batch)
+						}
+					}
+				}
+				if err != nil {
+					errMsg := fmt.Sprintf("Falha ao obter lote de %d mensagens da pasta '%s': %v", len(batch.Messages), folderName, err)
+					report.Errors = append(report.Errors, errMsg)
+					folderStats.FailedMessages += len(batch.Messages)
+					if firstFailedUID == 0 || batch.Messages[0].UID < firstFailedUID {
+						firstFailedUID = batch.Messages[0].UID
+					}
+					log.Printf("[%s] ERRO: %s", acc.SourceEmail, errMsg)
+					continue
+				}
 			}
 
-			// Tentar copiar com retry
-			var copyErr error
-			for attempt := 0; attempt <= config.MaxRetries; attempt++ {
-				if attempt > 0 {
-					log.Printf("[%s] Tentativa %d/%d para mensagem %d/%d", acc.SourceEmail, attempt, config.MaxRetries, i+1, len(messages))
+			for _, meta := range batch.Messages {
+				n := position[meta.UID]
+
+				if config.DryRun {
+					log.Printf("[%s] Copiando mensagem %d/%d da pasta '%s' (tamanho: %d bytes)...", acc.SourceEmail, n, total, folderName, meta.Size)
+					log.Printf("[%s] [DRY-RUN] Mensagem %d/%d seria copiada", acc.SourceEmail, n, total)
+					folderStats.CopiedMessages++
+					copiedCount++
+					sourceDups.MarkCopied(folderName, meta.UID)
+					if key, ok := dupKeys[meta.UID]; ok {
+						dupTracker.MarkAsCopied(acc.SourceEmail, folderName, destFolderName, key)
+					}
+					continue
 				}
 
-				appendCmd := destClient.Append(destFolderName, int64(len(bodyBytes)), &imap.AppendOptions{
-					Flags: validFlags,
-					Time:  msg.Envelope.Date,
-				})
+				// Verificar corpo da mensagem
+				bodyBytes, ok := bodies[meta.UID]
+				if !ok || len(bodyBytes) == 0 {
+					log.Printf("[%s] AVISO: mensagem %d/%d da pasta '%s' tem corpo vazio, pulando.", acc.SourceEmail, n, total, folderName)
+					folderStats.SkippedMessages++
+					continue
+				}
 
-				var writeErr error
-				_, writeErr = io.Copy(appendCmd, bytes.NewReader(bodyBytes))
+				// Com chave por conteúdo, a verificação só é possível agora; com Message-ID é repetida,
+				// porque uma mensagem anterior com a mesma chave pode ter sido copiada entretanto
+				if config.SkipDuplicates {
+					if dupTracker.UsesContent() {
+						dupKeys[meta.UID], _ = contentFingerprint(bytes.NewReader(bodyBytes), config.DuplicateKey)
+					}
+					if skipDuplicate(meta, total, dupKeys[meta.UID]) {
+						continue
+					}
+				}
+
+				validFlags := filterValidFlags(meta.Flags)
+
+				log.Printf("[%s] Copiando mensagem %d/%d da pasta '%s' (tamanho: %d bytes)...", acc.SourceEmail, n, total, folderName, len(bodyBytes))
 
-				closeErr := appendCmd.Close()
+				// Tentar copiar com retry
+				var copyErr error
+				var appendData *imap.AppendData
+				for attempt := 0; attempt <= config.MaxRetries; attempt++ {
+					if attempt > 0 {
+						log.Printf("[%s] Tentativa %d/%d para mensagem %d/%d", acc.SourceEmail, attempt, config.MaxRetries, n, total)
+					}
+
+					appendData, copyErr = appendMessage(destClient, destFolderName, bodyBytes, &imap.AppendOptions{
+						Flags: validFlags,
+						Time:  meta.MessageDate(config.DateSource),
+					})
+					if copyErr == nil {
+						break
+					}
 
-				if writeErr != nil {
-					if strings.Contains(writeErr.Error(), "OVERQUOTA") || strings.Contains(writeErr.Error(), "Quota exceeded") {
-						errMsg := fmt.Sprintf("Quota excedida no destino ao copiar mensagem %d/%d da pasta '%s'", i+1, len(messages), folderName)
+					if isQuotaError(copyErr) {
+						errMsg := fmt.Sprintf("Quota excedida no destino ao copiar mensagem %d/%d da pasta '%s'", n, total, folderName)
 						report.Errors = append(report.Errors, errMsg)
 						log.Printf("[%s] ERRO CRÍTICO: Quota excedida no destino!", acc.DestinationEmail)
-						return fmt.Errorf("quota excedida no destino: %w", writeErr)
+						return fmt.Errorf("quota excedida no destino: %w", copyErr)
 					}
-					copyErr = writeErr
-					continue
 				}
 
-				if closeErr != nil {
-					if strings.Contains(closeErr.Error(), "OVERQUOTA") || strings.Contains(closeErr.Error(), "Quota exceeded") {
-						errMsg := fmt.Sprintf("Quota excedida no destino ao finalizar mensagem %d/%d da pasta '%s'", i+1, len(messages), folderName)
-						report.Errors = append(report.Errors, errMsg)
-						log.Printf("[%s] ERRO CRÍTICO: Quota excedida no destino!", acc.DestinationEmail)
-						return fmt.Errorf("quota excedida no destino: %w", closeErr)
+				if copyErr != nil {
+					errMsg := fmt.Sprintf("Falha ao copiar mensagem %d/%d da pasta '%s' após %d tentativas: %v", n, total, folderName, config.MaxRetries+1, copyErr)
+					report.Errors = append(report.Errors, errMsg)
+					folderStats.FailedMessages++
+					if firstFailedUID == 0 || meta.UID < firstFailedUID {
+						firstFailedUID = meta.UID
 					}
-					copyErr = closeErr
+					log.Printf("[%s] ERRO: %s", acc.SourceEmail, errMsg)
 					continue
 				}
 
-				// Sucesso
-				copyErr = nil
-				break
-			}
+				copiedCount++
+				folderStats.CopiedMessages++
+				sourceDups.MarkCopied(folderName, meta.UID)
+				if config.SkipDuplicates {
+					dupTracker.MarkAsCopied(acc.SourceEmail, folderName, destFolderName, dupKeys[meta.UID])
+				}
+				log.Printf("[%s] Mensagem %d/%d copiada com sucesso para '%s'", acc.SourceEmail, n, total, destFolderName)
 
-			if copyErr != nil {
-				errMsg := fmt.Sprintf("Falha ao copiar mensagem %d/%d da pasta '%s' após %d tentativas: %v", i+1, len(messages), folderName, config.MaxRetries+1, copyErr)
-				report.Errors = append(report.Errors, errMsg)
-				folderStats.FailedMessages++
-				log.Printf("[%s] ERRO: %s", acc.SourceEmail, errMsg)
-				continue
+				if checkpoint != nil {
+					if err := checkpoint.MarkMigrated(folderName, meta.UID, appendData, validFlags); err != nil {
+						return fmt.Errorf("erro ao atualizar checkpoint: %w", err)
+					}
+				}
 			}
 
-			copiedCount++
-			folderStats.CopiedMessages++
-			log.Printf("[%s] Mensagem %d/%d copiada com sucesso para '%s'", acc.SourceEmail, i+1, len(messages), destFolderName)
+			if checkpoint != nil {
+				if err := checkpoint.Sync(); err != nil {
+					return fmt.Errorf("erro ao atualizar checkpoint: %w", err)
+				}
+			}
 		}
 
-		log.Printf("[%s] Pasta '%s': %d/%d mensagens copiadas com sucesso.", acc.SourceEmail, folderName, copiedCount, len(messages))
+		log.Printf("[%s] Pasta '%s': %d/%d mensagens copiadas com sucesso.", acc.SourceEmail, folderName, copiedCount, total)
+
+		// Guardar a marca de sincronização: o maior UID visto, ou o anterior à primeira falha
+		if checkpoint != nil {
+			mark := seenUID
+			if firstFailedUID > 0 && firstFailedUID-1 < mark {
+				mark = firstFailedUID - 1
+			}
+			modSeq := sourceData.HighestModSeq
+			if !flagsSynced {
+				modSeq = 0
+			}
+			if err := checkpoint.SetHighWaterMark(folderName, mark, modSeq); err != nil {
+				return fmt.Errorf("erro ao atualizar checkpoint: %w", err)
+			}
+		}
 
 		report.Folders = append(report.Folders, folderStats)
 	}
@@ -464,6 +794,7 @@ func migrateAccount(acc MigrationAccount, config MigrationConfig) error {
 		report.TotalCopied += folder.CopiedMessages
 		report.TotalFailed += folder.FailedMessages
 		report.TotalSkipped += folder.SkippedMessages
+		report.TotalFlagUpdates += folder.UpdatedFlags
 	}
 	report.Success = true
 
@@ -488,7 +819,7 @@ func main() {
 
 	log.Println("Iniciando a verificação de conexões...")
 
-	accounts, err := readCSV(config.AccountsFile)
+	accounts, err := readCSV(config.AccountsFile, config)
 	if err != nil {
 		log.Fatalf("ERRO FATAL: %v", err)
 	}
@@ -508,26 +839,36 @@ func main() {
 		wgCheck.Add(2)
 		go func(a MigrationAccount) {
 			defer wgCheck.Done()
-			err := testConnection(a.SourceHost, a.SourceUser, a.SourcePass)
+			ep := a.sourceEndpoint(config)
+			info, err := testConnection(ep)
 			mu.Lock()
-			if err != nil {
-				results <- fmt.Sprintf("❌ [Linha %d] Origem %s (%s): FALHOU - %v", a.LineNumber, a.SourceEmail, a.SourceHost, err)
+			var proxyErr *ProxyError
+			if errors.As(err, &proxyErr) {
+				results <- fmt.Sprintf("❌ [Linha %d] Origem %s (%s via %s): PROXY FALHOU - %v", a.LineNumber, a.SourceEmail, ep.Address(), proxyErr.Proxy, proxyErr.Err)
+				allConnectionsOK = false
+			} else if err != nil {
+				results <- fmt.Sprintf("❌ [Linha %d] Origem %s (%s, %s): FALHOU - %v", a.LineNumber, a.SourceEmail, ep.Address(), ep.Options.EffectiveSecurity(), err)
 				allConnectionsOK = false
 			} else {
-				results <- fmt.Sprintf("✅ [Linha %d] Origem %s (%s): OK", a.LineNumber, a.SourceEmail, a.SourceHost)
+				results <- fmt.Sprintf("✅ [Linha %d] Origem %s (%s, %s): OK - %s", a.LineNumber, a.SourceEmail, ep.Address(), ep.Options.EffectiveSecurity(), info)
 			}
 			mu.Unlock()
 		}(acc)
 
 		go func(a MigrationAccount) {
 			defer wgCheck.Done()
-			err := testConnection(a.DestinationHost, a.DestinationUser, a.DestinationPass)
+			ep := a.destinationEndpoint(config)
+			info, err := testConnection(ep)
 			mu.Lock()
-			if err != nil {
-				results <- fmt.Sprintf("❌ [Linha %d] Destino %s (%s): FALHOU - %v", a.LineNumber, a.DestinationEmail, a.DestinationHost, err)
+			var proxyErr *ProxyError
+			if errors.As(err, &proxyErr) {
+				results <- fmt.Sprintf("❌ [Linha %d] Destino %s (%s via %s): PROXY FALHOU - %v", a.LineNumber, a.DestinationEmail, ep.Address(), proxyErr.Proxy, proxyErr.Err)
+				allConnectionsOK = false
+			} else if err != nil {
+				results <- fmt.Sprintf("❌ [Linha %d] Destino %s (%s, %s): FALHOU - %v", a.LineNumber, a.DestinationEmail, ep.Address(), ep.Options.EffectiveSecurity(), err)
 				allConnectionsOK = false
 			} else {
-				results <- fmt.Sprintf("✅ [Linha %d] Destino %s (%s): OK", a.LineNumber, a.DestinationEmail, a.DestinationHost)
+				results <- fmt.Sprintf("✅ [Linha %d] Destino %s (%s, %s): OK - %s", a.LineNumber, a.DestinationEmail, ep.Address(), ep.Options.EffectiveSecurity(), info)
 			}
 			mu.Unlock()
 		}(acc)
@@ -552,20 +893,26 @@ func main() {
 		log.Println("\nTodas as conexões foram verificadas com sucesso. Iniciando a migração...")
 		log.Printf("Máximo de migrações simultâneas: %d\n", config.MaxConcurrentMigrations)
 
+		// Contas com o mesmo destino formam um só trabalho, migrado em sequência
+		groups := groupAccountsByDestination(accounts, config)
+		for _, g := range groups {
+			if g.IsMerge() {
+				log.Printf("Destino %s recebe %d contas de origem (%s); serão migradas em sequência", g.Destination, len(g.Accounts), g.sources())
+			}
+		}
+
 		semaphore := make(chan struct{}, config.MaxConcurrentMigrations)
 		var wgMigrate sync.WaitGroup
 
-		for _, acc := range accounts {
+		for _, group := range groups {
 			wgMigrate.Add(1)
 			semaphore <- struct{}{}
 
-			go func(a MigrationAccount) {
+			go func(g *destinationGroup) {
 				defer wgMigrate.Done()
-				if err := migrateAccount(a, config); err != nil {
-					log.Printf("ERRO NA MIGRAÇÃO de %s: %v", a.SourceEmail, err)
-				}
+				g.run(config)
 				<-semaphore
-			}(acc)
+			}(group)
 		}
 
 		wgMigrate.Wait()
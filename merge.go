@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// destinationGroup reúne as linhas do CSV que migram para a mesma conta de destino (mesmo
+// servidor, porta e utilizador). As contas de um grupo são migradas uma de cada vez, para que
+// a criação de pastas e os APPENDs não concorram no mesmo servidor, e partilham o rastreador
+// de duplicados: uma mensagem que exista em duas contas de origem só é copiada uma vez.
+type destinationGroup struct {
+	Destination string // email de destino da primeira linha do grupo
+	Accounts    []MigrationAccount
+
+	key        string // destinationKey comum às linhas do grupo
+	mu         sync.Mutex
+	dupTracker *DuplicateTracker
+	reports    []MigrationReport
+}
+
+// destinationKey identifica a conta de destino de uma linha, independentemente de maiúsculas.
+func destinationKey(acc MigrationAccount, config MigrationConfig) string {
+	ep := acc.destinationEndpoint(config)
+	return strings.ToLower(ep.Address()) + "|" + strings.ToLower(ep.User)
+}
+
+// groupAccountsByDestination agrupa as contas pelo destino, mantendo a ordem do CSV.
+func groupAccountsByDestination(accounts []MigrationAccount, config MigrationConfig) []*destinationGroup {
+	var groups []*destinationGroup
+	byKey := make(map[string]*destinationGroup)
+	for _, acc := range accounts {
+		key := destinationKey(acc, config)
+		group, ok := byKey[key]
+		if !ok {
+			group = &destinationGroup{Destination: acc.DestinationEmail, key: key}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		group.Accounts = append(group.Accounts, acc)
+	}
+	return groups
+}
+
+// IsMerge indica se várias contas de origem são juntadas neste destino.
+func (g *destinationGroup) IsMerge() bool {
+	return len(g.Accounts) > 1
+}
+
+// DuplicateTracker devolve o rastreador de duplicados partilhado pelas contas do grupo. O
+// âmbito decide se uma mensagem de uma conta conta como duplicada noutra; com state_dir, os
+// índices das pastas do destino ficam guardados no disco.
+func (g *destinationGroup) DuplicateTracker(config MigrationConfig) *DuplicateTracker {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.dupTracker == nil {
+		indexDir := ""
+		if config.StateDir != "" {
+			indexDir = dedupIndexDir(config.StateDir, g.key)
+		}
+		g.dupTracker = NewDuplicateTracker(config.DuplicateScope, config.DuplicateKey, indexDir, config.DuplicateSearchThreshold)
+	}
+	return g.dupTracker
+}
+
+// addReport regista o relatório de uma das contas do grupo.
+func (g *destinationGroup) addReport(report MigrationReport) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.reports = append(g.reports, report)
+}
+
+// sources devolve os emails de origem do grupo, separados por vírgulas.
+func (g *destinationGroup) sources() string {
+	emails := make([]string, len(g.Accounts))
+	for i, acc := range g.Accounts {
+		emails[i] = acc.SourceEmail
+	}
+	return strings.Join(emails, ", ")
+}
+
+// run migra as contas do grupo, uma de cada vez.
+func (g *destinationGroup) run(config MigrationConfig) {
+	for _, acc := range g.Accounts {
+		if err := migrateAccount(acc, config, g); err != nil {
+			log.Printf("ERRO NA MIGRAÇÃO de %s: %v", acc.SourceEmail, err)
+		}
+	}
+	if g.IsMerge() {
+		if err := saveMergeReport(g); err != nil {
+			log.Printf("[%s] AVISO: não foi possível guardar o relatório de fusão: %v", g.Destination, err)
+		}
+	}
+}
+
+// mergedFolder soma as estatísticas das pastas de origem que foram parar à mesma pasta do destino.
+type mergedFolder struct {
+	Name    string
+	Sources []string // "conta: pasta"
+	Stats   FolderStats
+}
+
+// saveMergeReport saves a report that presents the accounts of a group as one logical destination.
+func saveMergeReport(g *destinationGroup) error {
+	g.mu.Lock()
+	reports := g.reports
+	g.mu.Unlock()
+	if len(reports) == 0 {
+		return nil
+	}
+
+	reportsDir := "reports"
+	if err := os.MkdirAll(reportsDir, 0755); err != nil {
+		return fmt.Errorf("error creating reports directory: %w", err)
+	}
+
+	start, end := reports[0].StartTime, reports[0].EndTime
+	var folders []*mergedFolder
+	byName := make(map[string]*mergedFolder)
+	var total MigrationReport
+	success := true
+	for _, report := range reports {
+		if report.StartTime.Before(start) {
+			start = report.StartTime
+		}
+		if report.EndTime.After(end) {
+			end = report.EndTime
+		}
+		success = success && report.Success
+		total.TotalSourceMsgs += report.TotalSourceMsgs
+		total.TotalCopied += report.TotalCopied
+		total.TotalFailed += report.TotalFailed
+		total.TotalSkipped += report.TotalSkipped
+
+		for _, folder := range report.Folders {
+			key := strings.ToLower(folder.Destination)
+			merged, ok := byName[key]
+			if !ok {
+				merged = &mergedFolder{Name: folder.Destination}
+				byName[key] = merged
+				folders = append(folders, merged)
+			}
+			merged.Sources = append(merged.Sources, report.SourceEmail+": "+folder.Name)
+			merged.Stats.SourceMessages += folder.SourceMessages
+			merged.Stats.CopiedMessages += folder.CopiedMessages
+			merged.Stats.FailedMessages += folder.FailedMessages
+			merged.Stats.SkippedMessages += folder.SkippedMessages
+		}
+	}
+
+	safeEmail := strings.ReplaceAll(g.Destination, "@", "_at_")
+	safeEmail = strings.ReplaceAll(safeEmail, ".", "_")
+	filename := fmt.Sprintf("merge_%s_%s.txt", safeEmail, start.Format("20060102_150405"))
+	file, err := os.Create(filepath.Join(reportsDir, filename))
+	if err != nil {
+		return fmt.Errorf("error creating report file: %w", err)
+	}
+	defer file.Close()
+
+	fmt.Fprintf(file, "═══════════════════════════════════════════════════════════════════════════\n")
+	fmt.Fprintf(file, "                    IMAP MERGE REPORT\n")
+	fmt.Fprintf(file, "═══════════════════════════════════════════════════════════════════════════\n\n")
+
+	fmt.Fprintf(file, "Destination: %s\n", g.Destination)
+	fmt.Fprintf(file, "Sources:     %s\n", g.sources())
+	fmt.Fprintf(file, "Start:       %s\n", start.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(file, "End:         %s\n", end.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(file, "Duration:    %s\n", formatDuration(end.Sub(start)))
+	if success && len(reports) == len(g.Accounts) {
+		fmt.Fprintf(file, "Status:      ✓ COMPLETED SUCCESSFULLY\n")
+	} else {
+		fmt.Fprintf(file, "Status:      ✗ INTERRUPTED (see the per-account reports)\n")
+	}
+	fmt.Fprintf(file, "\n")
+
+	fmt.Fprintf(file, "───────────────────────────────────────────────────────────────────────────\n")
+	fmt.Fprintf(file, "                           SUMMARY\n")
+	fmt.Fprintf(file, "───────────────────────────────────────────────────────────────────────────\n\n")
+
+	fmt.Fprintf(file, "%-50s %8s %8s %8s %8s\n", "SOURCE ACCOUNT", "SOURCE", "COPIED", "FAILED", "SKIPPED")
+	fmt.Fprintf(file, "%-50s %8s %8s %8s %8s\n", strings.Repeat("-", 50), "--------", "--------", "--------", "--------")
+	for _, report := range reports {
+		fmt.Fprintf(file, "%-50s %8d %8d %8d %8d\n", truncateRunes(report.SourceEmail, 50),
+			report.TotalSourceMsgs, report.TotalCopied, report.TotalFailed, report.TotalSkipped)
+	}
+	fmt.Fprintf(file, "%-50s %8d %8d %8d %8d\n", "TOTAL",
+		total.TotalSourceMsgs, total.TotalCopied, total.TotalFailed, total.TotalSkipped)
+	fmt.Fprintf(file, "\n")
+
+	fmt.Fprintf(file, "───────────────────────────────────────────────────────────────────────────\n")
+	fmt.Fprintf(file, "                 DESTINATION FOLDERS (MERGED)\n")
+	fmt.Fprintf(file, "───────────────────────────────────────────────────────────────────────────\n\n")
+
+	fmt.Fprintf(file, "%-50s %8s %8s %8s %8s\n", "FOLDER", "SOURCE", "COPIED", "FAILED", "SKIPPED")
+	fmt.Fprintf(file, "%-50s %8s %8s %8s %8s\n", strings.Repeat("-", 50), "--------", "--------", "--------", "--------")
+	for _, folder := range folders {
+		fmt.Fprintf(file, "%-50s %8d %8d %8d %8d\n", truncateRunes(folder.Name, 50),
+			folder.Stats.SourceMessages, folder.Stats.CopiedMessages, folder.Stats.FailedMessages, folder.Stats.SkippedMessages)
+		for _, source := range folder.Sources {
+			fmt.Fprintf(file, "  <- %s\n", source)
+		}
+	}
+	fmt.Fprintf(file, "\n")
+
+	fmt.Fprintf(file, "═══════════════════════════════════════════════════════════════════════════\n")
+	fmt.Fprintf(file, "Report generated at: %s\n", time.Now().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(file, "═══════════════════════════════════════════════════════════════════════════\n")
+
+	return nil
+}
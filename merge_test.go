@@ -0,0 +1,35 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGroupAccountsByDestination(t *testing.T) {
+	config := DefaultConfig()
+	accounts := []MigrationAccount{
+		{SourceEmail: "a@old", DestinationEmail: "equipa@novo", DestinationUser: "equipa@novo", DestinationHost: "imap.novo"},
+		{SourceEmail: "b@old", DestinationEmail: "Equipa@Novo", DestinationUser: "EQUIPA@novo", DestinationHost: "IMAP.novo"},
+		// Mesmo email, outro servidor: outra conta de destino
+		{SourceEmail: "c@old", DestinationEmail: "equipa@novo", DestinationUser: "equipa@novo", DestinationHost: "imap.outro"},
+	}
+
+	groups := groupAccountsByDestination(accounts, config)
+	if len(groups) != 2 {
+		t.Fatalf("%d grupos, esperado 2", len(groups))
+	}
+	if !groups[0].IsMerge() || len(groups[0].Accounts) != 2 || groups[1].IsMerge() {
+		t.Errorf("grupos: %d e %d contas", len(groups[0].Accounts), len(groups[1].Accounts))
+	}
+
+	// Os índices de duplicados seguem a mesma chave: contas com o mesmo email em servidores
+	// diferentes não partilham o diretório
+	dir0 := dedupIndexDir("state", groups[0].key)
+	dir1 := dedupIndexDir("state", groups[1].key)
+	if dir0 == dir1 {
+		t.Errorf("o mesmo diretório de índices para dois destinos: %s", dir0)
+	}
+	if want := filepath.Join("state", "dedup_imap_novo_993__equipa_at_novo"); dir0 != want {
+		t.Errorf("dedupIndexDir = %s, esperado %s", dir0, want)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// messageMeta guarda os metadados de uma mensagem de origem, sem o corpo.
+type messageMeta struct {
+	UID          imap.UID
+	Size         int64
+	Flags        []imap.Flag
+	Envelope     *imap.Envelope
+	InternalDate time.Time
+	ReceivedDate time.Time // só obtida com date_source "received-header"
+}
+
+// EnvelopeDate retorna a data do cabeçalho Date, ou zero se não houver envelope.
+func (m messageMeta) EnvelopeDate() time.Time {
+	if m.Envelope == nil {
+		return time.Time{}
+	}
+	return m.Envelope.Date
+}
+
+// MessageID retorna o Message-ID do envelope, ou vazio se não houver.
+func (m messageMeta) MessageID() string {
+	if m.Envelope == nil {
+		return ""
+	}
+	return m.Envelope.MessageID
+}
+
+// messageBatch é um conjunto de mensagens cujos corpos são obtidos num único FETCH.
+type messageBatch struct {
+	Messages []messageMeta
+	Bytes    int64
+}
+
+// folderUIDSet devolve o conjunto de UIDs de uma pasta a partir dos dados do SELECT.
+func folderUIDSet(data *imap.SelectData) imap.UIDSet {
+	uidSet := imap.UIDSet{}
+	if data.UIDNext > 1 {
+		uidSet.AddRange(1, data.UIDNext-1)
+	} else {
+		// Servidor não reportou UIDNEXT: pedir 1:*
+		uidSet.AddRange(1, 0)
+	}
+	return uidSet
+}
+
+// fetchMessageMetadata obtém UID, tamanho, flags, envelope e data interna de todas as mensagens
+// da pasta selecionada, sem descarregar corpos. Com date_source "received-header" obtém também
+// os cabeçalhos Received.
+func fetchMessageMetadata(client *imapclient.Client, uidSet imap.UIDSet, dateSource string) ([]messageMeta, error) {
+	fetchOptions := &imap.FetchOptions{
+		UID:          true,
+		RFC822Size:   true,
+		Flags:        true,
+		Envelope:     true,
+		InternalDate: true,
+	}
+	receivedSection := &imap.FetchItemBodySection{
+		Specifier:    imap.PartSpecifierHeader,
+		HeaderFields: []string{"Received"},
+		Peek:         true,
+	}
+	if dateSource == DateSourceReceived {
+		fetchOptions.BodySection = []*imap.FetchItemBodySection{receivedSection}
+	}
+
+	var metas []messageMeta
+	cmd := client.Fetch(uidSet, fetchOptions)
+	for {
+		msg := cmd.Next()
+		if msg == nil {
+			break
+		}
+		buf, err := msg.Collect()
+		if err != nil {
+			cmd.Close()
+			return nil, err
+		}
+		meta := messageMeta{
+			UID:          buf.UID,
+			Size:         buf.RFC822Size,
+			Flags:        buf.Flags,
+			Envelope:     buf.Envelope,
+			InternalDate: buf.InternalDate,
+		}
+		if header := buf.FindBodySection(receivedSection); header != nil {
+			meta.ReceivedDate = parseReceivedDate(header)
+		}
+		metas = append(metas, meta)
+	}
+	if err := cmd.Close(); err != nil {
+		return nil, err
+	}
+	return metas, nil
+}
+
+// planBatches divide as mensagens em lotes limitados em número e em bytes.
+// Uma mensagem maior que o orçamento fica sozinha no seu lote.
+func planBatches(metas []messageMeta, maxMessages int, maxBytes int64) []messageBatch {
+	var batches []messageBatch
+	var current messageBatch
+	for _, meta := range metas {
+		full := len(current.Messages) >= maxMessages ||
+			(maxBytes > 0 && current.Bytes+meta.Size > maxBytes)
+		if len(current.Messages) > 0 && full {
+			batches = append(batches, current)
+			current = messageBatch{}
+		}
+		current.Messages = append(current.Messages, meta)
+		current.Bytes += meta.Size
+	}
+	if len(current.Messages) > 0 {
+		batches = append(batches, current)
+	}
+	return batches
+}
+
+// fetchBatchBodies obtém os corpos completos das mensagens de um lote, indexados por UID.
+// Usa BODY.PEEK[] para não marcar as mensagens como lidas na origem.
+func fetchBatchBodies(client *imapclient.Client, batch messageBatch) (map[imap.UID][]byte, error) {
+	uidSet := imap.UIDSet{}
+	for _, meta := range batch.Messages {
+		uidSet.AddNum(meta.UID)
+	}
+
+	fetchOptions := &imap.FetchOptions{
+		UID:         true,
+		BodySection: []*imap.FetchItemBodySection{{Peek: true}},
+	}
+
+	messages, err := client.Fetch(uidSet, fetchOptions).Collect()
+	if err != nil {
+		return nil, err
+	}
+
+	bodies := make(map[imap.UID][]byte, len(messages))
+	for _, msg := range messages {
+		if len(msg.BodySection) == 0 {
+			continue
+		}
+		bodies[msg.UID] = msg.BodySection[0].Bytes
+	}
+	return bodies, nil
+}
+
+// appendMessage envia uma mensagem para a pasta de destino e aguarda a resposta do servidor.
+// Os dados devolvidos incluem o UID atribuído se o servidor suportar UIDPLUS.
+func appendMessage(client *imapclient.Client, folder string, body []byte, options *imap.AppendOptions) (*imap.AppendData, error) {
+	appendCmd := client.Append(folder, int64(len(body)), options)
+	_, writeErr := appendCmd.Write(body)
+	closeErr := appendCmd.Close()
+	if writeErr != nil {
+		return nil, writeErr
+	}
+	if closeErr != nil {
+		return nil, closeErr
+	}
+	return appendCmd.Wait()
+}
+
+// isQuotaError verifica se um erro do servidor indica quota excedida.
+func isQuotaError(err error) bool {
+	return strings.Contains(err.Error(), "OVERQUOTA") || strings.Contains(err.Error(), "Quota exceeded")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+func TestPlanBatches(t *testing.T) {
+	tests := []struct {
+		name        string
+		sizes       []int64
+		maxMessages int
+		maxBytes    int64
+		want        [][]int64 // tamanhos por lote
+	}{
+		{name: "vazio", maxMessages: 10, maxBytes: 100},
+		{name: "um lote", sizes: []int64{10, 20, 30}, maxMessages: 10, maxBytes: 100, want: [][]int64{{10, 20, 30}}},
+		{name: "limite de mensagens", sizes: []int64{1, 1, 1, 1, 1}, maxMessages: 2, maxBytes: 100, want: [][]int64{{1, 1}, {1, 1}, {1}}},
+		{name: "limite de bytes", sizes: []int64{40, 40, 40, 20}, maxMessages: 10, maxBytes: 100, want: [][]int64{{40, 40}, {40, 20}}},
+		{name: "exatamente no orçamento", sizes: []int64{50, 50, 1}, maxMessages: 10, maxBytes: 100, want: [][]int64{{50, 50}, {1}}},
+		{name: "mensagem maior que o orçamento", sizes: []int64{10, 500, 10}, maxMessages: 10, maxBytes: 100, want: [][]int64{{10}, {500}, {10}}},
+		{name: "sem limite de bytes", sizes: []int64{500, 500, 500}, maxMessages: 2, maxBytes: 0, want: [][]int64{{500, 500}, {500}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metas := make([]messageMeta, len(tt.sizes))
+			for i, size := range tt.sizes {
+				metas[i] = messageMeta{UID: imap.UID(i + 1), Size: size}
+			}
+
+			var got [][]int64
+			for _, batch := range planBatches(metas, tt.maxMessages, tt.maxBytes) {
+				var sizes []int64
+				var total int64
+				for _, meta := range batch.Messages {
+					sizes = append(sizes, meta.Size)
+					total += meta.Size
+				}
+				if batch.Bytes != total {
+					t.Errorf("lote com Bytes = %d, soma dos tamanhos %d", batch.Bytes, total)
+				}
+				got = append(got, sizes)
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal[[]int64]) {
+				t.Errorf("planBatches = %v, esperado %v", got, tt.want)
+			}
+		})
+	}
+}
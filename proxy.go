@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// proxyDialTimeout limita o tempo para ligar ao proxy e estabelecer o túnel.
+const proxyDialTimeout = 30 * time.Second
+
+// ProxyError indica que a falha ocorreu no proxy e não no servidor IMAP.
+type ProxyError struct {
+	Proxy string
+	Err   error
+}
+
+func (e *ProxyError) Error() string {
+	return fmt.Sprintf("falha no proxy %s: %v", e.Proxy, e.Err)
+}
+
+func (e *ProxyError) Unwrap() error {
+	return e.Err
+}
+
+// parseProxyURL valida um URL de proxy (socks5://, http://). "direct" ou "none" desativam o proxy.
+func parseProxyURL(raw string) (*url.URL, error) {
+	if raw == "" || raw == "direct" || raw == "none" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("URL de proxy inválido '%s': %w", raw, err)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return nil, fmt.Errorf("esquema de proxy não suportado '%s' (use socks5 ou http)", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("URL de proxy sem host: '%s'", raw)
+	}
+	if u.Port() == "" {
+		port := "1080"
+		if u.Scheme == "http" {
+			port = "8080"
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u, nil
+}
+
+// redactedProxy devolve o proxy sem credenciais, para logs e relatórios.
+func redactedProxy(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// dialThroughProxy abre uma ligação TCP para addr através do proxy indicado.
+func dialThroughProxy(proxyURL *url.URL, addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", proxyURL.Host, proxyDialTimeout)
+	if err != nil {
+		return nil, &ProxyError{Proxy: redactedProxy(proxyURL), Err: err}
+	}
+	conn.SetDeadline(time.Now().Add(proxyDialTimeout))
+
+	if proxyURL.Scheme == "http" {
+		err = httpConnect(conn, proxyURL, addr)
+	} else {
+		err = socks5Connect(conn, proxyURL, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, &ProxyError{Proxy: redactedProxy(proxyURL), Err: err}
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// httpConnect estabelece um túnel com o método HTTP CONNECT.
+func httpConnect(conn net.Conn, proxyURL *url.URL, addr string) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("erro ao enviar CONNECT: %w", err)
+	}
+
+	// Ler byte a byte até ao fim do cabeçalho para não consumir a saudação IMAP
+	var header []byte
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(header, []byte("\r\n\r\n")) {
+		if len(header) > 8192 {
+			return fmt.Errorf("resposta ao CONNECT demasiado longa")
+		}
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return fmt.Errorf("erro ao ler resposta ao CONNECT: %w", err)
+		}
+		header = append(header, b[0])
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(header)), req)
+	if err != nil {
+		return fmt.Errorf("resposta inválida ao CONNECT: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CONNECT recusado: %s", resp.Status)
+	}
+	return nil
+}
+
+// socks5Connect negoceia uma ligação SOCKS5 (RFC 1928) com autenticação opcional (RFC 1929).
+func socks5Connect(conn net.Conn, proxyURL *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("porta inválida '%s'", portStr)
+	}
+
+	// Saudação: sem autenticação e, se houver credenciais, utilizador/senha
+	methods := []byte{0x00}
+	if proxyURL.User != nil {
+		methods = []byte{0x00, 0x02}
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return fmt.Errorf("erro na saudação SOCKS5: %w", err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("erro na saudação SOCKS5: %w", err)
+	}
+	if reply[0] != 0x05 {
+		return fmt.Errorf("proxy não fala SOCKS5")
+	}
+
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if proxyURL.User == nil {
+			return fmt.Errorf("proxy SOCKS5 exige autenticação")
+		}
+		username := proxyURL.User.Username()
+		password, _ := proxyURL.User.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return fmt.Errorf("credenciais SOCKS5 demasiado longas")
+		}
+		msg := []byte{0x01, byte(len(username))}
+		msg = append(msg, username...)
+		msg = append(msg, byte(len(password)))
+		msg = append(msg, password...)
+		if _, err := conn.Write(msg); err != nil {
+			return fmt.Errorf("erro na autenticação SOCKS5: %w", err)
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return fmt.Errorf("erro na autenticação SOCKS5: %w", err)
+		}
+		if reply[1] != 0x00 {
+			return fmt.Errorf("autenticação SOCKS5 recusada")
+		}
+	default:
+		return fmt.Errorf("proxy SOCKS5 não aceitou nenhum método de autenticação")
+	}
+
+	// Pedido CONNECT, deixando a resolução do nome ao proxy
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(req, 0x01)
+		req = append(req, ip.To4()...)
+	} else if ip != nil {
+		req = append(req, 0x04)
+		req = append(req, ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("nome de host demasiado longo para SOCKS5")
+		}
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("erro no pedido SOCKS5: %w", err)
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("erro na resposta SOCKS5: %w", err)
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("proxy SOCKS5 recusou a ligação (código %d)", header[1])
+	}
+
+	// Descartar o endereço de ligação devolvido pelo proxy
+	var skip int
+	switch header[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return fmt.Errorf("erro na resposta SOCKS5: %w", err)
+		}
+		skip = int(length[0])
+	default:
+		return fmt.Errorf("tipo de endereço SOCKS5 desconhecido: %d", header[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, skip+2)); err != nil {
+		return fmt.Errorf("erro na resposta SOCKS5: %w", err)
+	}
+	return nil
+}
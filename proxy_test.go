@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseProxyURL(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string // "" = sem proxy
+		wantErr bool
+	}{
+		{raw: ""},
+		{raw: "direct"},
+		{raw: "none"},
+		{raw: "socks5://proxy", want: "socks5://proxy:1080"},
+		{raw: "socks5h://u:p@proxy:9050", want: "socks5h://proxy:9050"},
+		{raw: "http://proxy", want: "http://proxy:8080"},
+		{raw: "https://proxy:443", wantErr: true},
+		{raw: "socks5://", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			u, err := parseProxyURL(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("erro = %v, esperado erro = %v", err, tt.wantErr)
+			}
+			got := ""
+			if u != nil {
+				got = redactedProxy(u)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseProxyURL(%q) = %q, esperado %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSocks5Connect(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxy   string
+		addr    string
+		method  byte   // método escolhido pelo proxy
+		authOK  bool   // resposta à autenticação
+		status  byte   // código da resposta ao CONNECT
+		bound   []byte // ATYP e endereço de ligação devolvidos pelo proxy
+		request []byte // pedido CONNECT esperado (sem a porta)
+		wantErr string
+	}{
+		{
+			name: "sem autenticação, IPv4", proxy: "socks5://proxy", addr: "10.0.0.1:993",
+			bound:   []byte{0x01, 0, 0, 0, 0},
+			request: []byte{0x05, 0x01, 0x00, 0x01, 10, 0, 0, 1},
+		},
+		{
+			name: "nome resolvido pelo proxy", proxy: "socks5://proxy", addr: "imap.test:993",
+			bound:   []byte{0x03, 4, 'h', 'o', 's', 't'},
+			request: append([]byte{0x05, 0x01, 0x00, 0x03, 9}, "imap.test"...),
+		},
+		{
+			name: "IPv6", proxy: "socks5://proxy", addr: "[::1]:993",
+			bound:   append([]byte{0x04}, make([]byte, 16)...),
+			request: append([]byte{0x05, 0x01, 0x00, 0x04}, net.IPv6loopback...),
+		},
+		{
+			name: "utilizador e senha", proxy: "socks5://u:p@proxy", addr: "10.0.0.1:993",
+			method: 0x02, authOK: true,
+			bound:   []byte{0x01, 0, 0, 0, 0},
+			request: []byte{0x05, 0x01, 0x00, 0x01, 10, 0, 0, 1},
+		},
+		{
+			name: "autenticação recusada", proxy: "socks5://u:p@proxy", addr: "10.0.0.1:993",
+			method: 0x02, wantErr: "autenticação SOCKS5 recusada",
+		},
+		{
+			name: "autenticação exigida sem credenciais", proxy: "socks5://proxy", addr: "10.0.0.1:993",
+			method: 0x02, wantErr: "exige autenticação",
+		},
+		{
+			name: "nenhum método aceite", proxy: "socks5://proxy", addr: "10.0.0.1:993",
+			method: 0xff, wantErr: "não aceitou nenhum método",
+		},
+		{
+			name: "ligação recusada", proxy: "socks5://proxy", addr: "10.0.0.1:993",
+			status: 0x05, bound: []byte{0x01, 0, 0, 0, 0},
+			request: []byte{0x05, 0x01, 0x00, 0x01, 10, 0, 0, 1},
+			wantErr: "código 5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxyURL, err := url.Parse(tt.proxy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, server := net.Pipe()
+			defer client.Close()
+
+			done := make(chan []byte, 1)
+			go func() {
+				defer server.Close()
+				done <- fakeSocks5(server, tt.method, tt.authOK, tt.status, tt.bound)
+			}()
+
+			err = socks5Connect(client, proxyURL, tt.addr)
+			client.Close()
+			request := <-done
+
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("erro inesperado: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("erro = %v, esperado %q", err, tt.wantErr)
+			}
+			if tt.request != nil {
+				want := append(tt.request, 0x03, 0xe1) // porta 993
+				if !bytes.Equal(request, want) {
+					t.Errorf("pedido CONNECT % x, esperado % x", request, want)
+				}
+			}
+		})
+	}
+}
+
+// fakeSocks5 faz de proxy SOCKS5 numa ponta de net.Pipe e devolve o pedido CONNECT recebido.
+func fakeSocks5(conn net.Conn, method byte, authOK bool, status byte, bound []byte) []byte {
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return nil
+	}
+	if _, err := io.ReadFull(conn, make([]byte, greeting[1])); err != nil {
+		return nil
+	}
+	conn.Write([]byte{0x05, method})
+	switch method {
+	case 0x00:
+	case 0x02:
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil
+		}
+		user := make([]byte, buf[1]+1)
+		io.ReadFull(conn, user)
+		io.ReadFull(conn, make([]byte, user[len(user)-1]))
+		if !authOK {
+			conn.Write([]byte{0x01, 0x01})
+			return nil
+		}
+		conn.Write([]byte{0x01, 0x00})
+	default:
+		return nil
+	}
+
+	// VER CMD RSV ATYP, endereço e porta
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return nil
+	}
+	var length int
+	switch request[3] {
+	case 0x01:
+		length = net.IPv4len
+	case 0x04:
+		length = net.IPv6len
+	case 0x03:
+		n := make([]byte, 1)
+		io.ReadFull(conn, n)
+		request = append(request, n[0])
+		length = int(n[0])
+	}
+	rest := make([]byte, length+2)
+	io.ReadFull(conn, rest)
+	request = append(request, rest...)
+
+	reply := append([]byte{0x05, status, 0x00}, bound...)
+	conn.Write(append(reply, 0, 0))
+	return request
+}
+
+func TestHTTPConnect(t *testing.T) {
+	tests := []struct {
+		name     string
+		proxy    string
+		response string
+		wantAuth string
+		wantErr  string
+	}{
+		{name: "aceite", proxy: "http://proxy", response: "HTTP/1.1 200 Connection established\r\n\r\n"},
+		{
+			name: "com credenciais", proxy: "http://u:p@proxy",
+			response: "HTTP/1.1 200 OK\r\n\r\n", wantAuth: "Basic dTpw",
+		},
+		{
+			name: "recusado", proxy: "http://proxy",
+			response: "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n", wantErr: "CONNECT recusado: 407",
+		},
+		{name: "resposta inválida", proxy: "http://proxy", response: "SSH-2.0\r\n\r\n", wantErr: "resposta inválida"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxyURL, err := url.Parse(tt.proxy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, server := net.Pipe()
+			defer client.Close()
+
+			requests := make(chan *http.Request, 1)
+			go func() {
+				defer server.Close()
+				req, err := http.ReadRequest(bufio.NewReader(server))
+				if err != nil {
+					requests <- nil
+					return
+				}
+				requests <- req
+				// A saudação IMAP vem logo a seguir e não pode ser consumida pelo httpConnect
+				server.Write([]byte(tt.response + "* OK IMAP\r\n"))
+			}()
+
+			err = httpConnect(client, proxyURL, "imap.test:993")
+			req := <-requests
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("erro inesperado: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("erro = %v, esperado %q", err, tt.wantErr)
+			}
+			if req == nil || req.Method != http.MethodConnect || req.Host != "imap.test:993" {
+				t.Fatalf("pedido inesperado: %+v", req)
+			}
+			if got := req.Header.Get("Proxy-Authorization"); got != tt.wantAuth {
+				t.Errorf("Proxy-Authorization = %q, esperado %q", got, tt.wantAuth)
+			}
+			if tt.wantErr == "" {
+				greeting, _ := bufio.NewReader(client).ReadString('\n')
+				if greeting != "* OK IMAP\r\n" {
+					t.Errorf("saudação IMAP %q, esperado \"* OK IMAP\\r\\n\"", greeting)
+				}
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 )
@@ -60,6 +61,9 @@ func saveReport(report MigrationReport) error {
 	fmt.Fprintf(file, "Total messages copied:           %d\n", report.TotalCopied)
 	fmt.Fprintf(file, "Total messages failed:           %d\n", report.TotalFailed)
 	fmt.Fprintf(file, "Total messages skipped:          %d\n", report.TotalSkipped)
+	if report.TotalFlagUpdates > 0 {
+		fmt.Fprintf(file, "Total flag updates:              %d\n", report.TotalFlagUpdates)
+	}
 	
 	if report.TotalSourceMsgs > 0 {
 		successRate := float64(report.TotalCopied) / float64(report.TotalSourceMsgs) * 100
@@ -78,11 +82,8 @@ func saveReport(report MigrationReport) error {
 	fmt.Fprintf(file, "%-50s %8s %8s %8s %8s\n", strings.Repeat("-", 50), "--------", "--------", "--------", "--------")
 	
 	for _, folder := range report.Folders {
-		// Truncate folder name if too long
-		folderName := folder.Name
-		if len(folderName) > 50 {
-			folderName = folderName[:47] + "..."
-		}
+		// Truncate folder name if too long (by characters, so UTF-8 names are not cut mid-rune)
+		folderName := truncateRunes(folder.Name, 50)
 		
 		fmt.Fprintf(file, "%-50s %8d %8d %8d %8d\n",
 			folderName,
@@ -94,6 +95,79 @@ func saveReport(report MigrationReport) error {
 	
 	fmt.Fprintf(file, "\n")
 	
+	// Duplicates skipped, grouped by where the message had already been seen
+	{
+		var lines []string
+		for _, folder := range report.Folders {
+			origins := make([]string, 0, len(folder.DuplicateSkips))
+			for origin := range folder.DuplicateSkips {
+				origins = append(origins, origin)
+			}
+			slices.Sort(origins)
+			for _, origin := range origins {
+				lines = append(lines, fmt.Sprintf("%s: %d %s", folder.Name, folder.DuplicateSkips[origin], origin))
+			}
+		}
+		
+		if len(lines) > 0 {
+			fmt.Fprintf(file, "───────────────────────────────────────────────────────────────────────────\n")
+			if report.DuplicateScope != "" {
+				fmt.Fprintf(file, "              DUPLICATES SKIPPED (scope: %s)\n", report.DuplicateScope)
+			} else {
+				fmt.Fprintf(file, "                    DUPLICATES SKIPPED\n")
+			}
+			fmt.Fprintf(file, "───────────────────────────────────────────────────────────────────────────\n\n")
+			
+			for _, line := range lines {
+				fmt.Fprintf(file, "%s\n", line)
+			}
+			
+			fmt.Fprintf(file, "\n")
+		}
+	}
+	
+	// Messages found in several source folders (scan mode or source_duplicates)
+	if report.SourceDuplicatePolicy != "" {
+		fmt.Fprintf(file, "───────────────────────────────────────────────────────────────────────────\n")
+		fmt.Fprintf(file, "              SOURCE DUPLICATES (policy: %s)\n", report.SourceDuplicatePolicy)
+		fmt.Fprintf(file, "───────────────────────────────────────────────────────────────────────────\n\n")
+		
+		if len(report.SourceDuplicates) == 0 {
+			fmt.Fprintf(file, "No message appears in more than one source folder.\n")
+		}
+		for _, line := range report.SourceDuplicates {
+			fmt.Fprintf(file, "%s\n", line)
+		}
+		
+		fmt.Fprintf(file, "\n")
+	}
+	
+	// Folder plan (plan mode)
+	if len(report.FolderPlan) > 0 {
+		fmt.Fprintf(file, "───────────────────────────────────────────────────────────────────────────\n")
+		fmt.Fprintf(file, "                    FOLDER PLAN (SOURCE -> DESTINATION)\n")
+		fmt.Fprintf(file, "───────────────────────────────────────────────────────────────────────────\n\n")
+		
+		for _, entry := range report.FolderPlan {
+			fmt.Fprintf(file, "%s -> %s  [%s]\n", entry.Source, entry.Destination, entry.Origin.Report())
+		}
+		
+		fmt.Fprintf(file, "\n")
+	}
+	
+	// Folder collisions (if any)
+	if len(report.FolderCollisions) > 0 {
+		fmt.Fprintf(file, "───────────────────────────────────────────────────────────────────────────\n")
+		fmt.Fprintf(file, "                      FOLDER COLLISIONS\n")
+		fmt.Fprintf(file, "───────────────────────────────────────────────────────────────────────────\n\n")
+		
+		for i, collision := range report.FolderCollisions {
+			fmt.Fprintf(file, "%d. %s\n", i+1, collision)
+		}
+		
+		fmt.Fprintf(file, "\n")
+	}
+	
 	// Errors (if any)
 	if len(report.Errors) > 0 {
 		fmt.Fprintf(file, "───────────────────────────────────────────────────────────────────────────\n")
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// Políticas para mensagens que aparecem em várias pastas da origem (etiquetas do Gmail e
+// "[Gmail]/All Mail", exportações do Exchange).
+const (
+	SourceDuplicatesKeep = "keep" // copiar a mensagem em todas as pastas onde está (padrão)
+	SourceDuplicatesOnce = "once" // copiar só na pasta de maior prioridade (folder_priority)
+)
+
+// sourceCopy é uma ocorrência de uma mensagem numa pasta de origem.
+type sourceCopy struct {
+	Folder string
+	UID    imap.UID
+}
+
+// sourceDuplicateScan é o resultado da análise de duplicados na origem.
+type sourceDuplicateScan struct {
+	Messages int                     // mensagens analisadas
+	Groups   map[string][]sourceCopy // chave -> ocorrências por prioridade, só as com mais de uma
+	groupOf  map[sourceCopy]string   // ocorrência -> chave do seu grupo
+	copied   map[sourceCopy]bool     // ocorrências que já estão no destino nesta execução
+	queued   map[sourceCopy]bool     // ocorrências selecionadas para cópia na pasta em curso
+}
+
+// folderPriority ordena as pastas de origem para a política once: primeiro as que
+// correspondem a folder_priority, pela ordem das regras; depois as restantes, pela ordem do
+// plano; por fim as pastas com o atributo \All, que repetem todas as outras.
+func folderPriority(plan []folderPlanEntry, mailboxes []*imap.ListData, rules []folderRule) map[string]int {
+	allMail := make(map[string]bool)
+	for _, mb := range mailboxes {
+		if hasMailboxAttr(mb.Attrs, imap.MailboxAttrAll) {
+			allMail[mb.Mailbox] = true
+		}
+	}
+
+	ranks := make(map[string]int, len(plan))
+	for i, entry := range plan {
+		rank := len(rules) + i
+		for r, rule := range rules {
+			if rule.re.MatchString(entry.Source) {
+				rank = r
+				break
+			}
+		}
+		if allMail[entry.Source] && rank >= len(rules) {
+			rank += len(plan)
+		}
+		ranks[entry.Source] = rank
+	}
+	return ranks
+}
+
+// scanSourceDuplicates agrupa as mensagens de todas as pastas do plano pela chave de
+// duplicados (Message-ID ou impressão digital do conteúdo) e escolhe, para cada grupo, a
+// pasta de maior prioridade. As pastas são abertas com EXAMINE.
+func scanSourceDuplicates(client *imapclient.Client, email string, plan []folderPlanEntry, ranks map[string]int, keyMode string) (*sourceDuplicateScan, error) {
+	scan := &sourceDuplicateScan{
+		Groups:  make(map[string][]sourceCopy),
+		groupOf: make(map[sourceCopy]string),
+		copied:  make(map[sourceCopy]bool),
+		queued:  make(map[sourceCopy]bool),
+	}
+
+	all := make(map[string][]sourceCopy)
+	for _, entry := range plan {
+		data, err := client.Select(entry.Source, &imap.SelectOptions{ReadOnly: true}).Wait()
+		if err != nil {
+			return nil, fmt.Errorf("erro ao selecionar a pasta '%s': %w", entry.Source, err)
+		}
+		if data.NumMessages == 0 {
+			continue
+		}
+		keys, err := fetchDedupKeys(client, folderUIDSet(data), keyMode)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao analisar a pasta '%s': %w", entry.Source, err)
+		}
+		log.Printf("[%s] Análise de duplicados na origem: pasta '%s', %d mensagens", email, entry.Source, len(keys))
+		scan.Messages += len(keys)
+		for uid, key := range keys {
+			if key == "" {
+				continue // sem Message-ID: não se sabe se é repetida
+			}
+			all[key] = append(all[key], sourceCopy{Folder: entry.Source, UID: uid})
+		}
+	}
+
+	for key, copies := range all {
+		if len(copies) < 2 {
+			continue
+		}
+		slices.SortStableFunc(copies, func(a, b sourceCopy) int {
+			if ranks[a.Folder] != ranks[b.Folder] {
+				return ranks[a.Folder] - ranks[b.Folder]
+			}
+			return int(a.UID) - int(b.UID)
+		})
+		scan.Groups[key] = copies
+		for _, c := range copies {
+			scan.groupOf[c] = key
+		}
+	}
+	return scan, nil
+}
+
+// sortByPriority devolve uma cópia do plano ordenada pela prioridade das pastas, para que a
+// ocorrência preferida de cada mensagem seja tentada antes das outras.
+func sortByPriority(plan []folderPlanEntry, ranks map[string]int) []folderPlanEntry {
+	sorted := slices.Clone(plan)
+	slices.SortStableFunc(sorted, func(a, b folderPlanEntry) int {
+		return ranks[a.Source] - ranks[b.Source]
+	})
+	return sorted
+}
+
+// MarkCopied regista que uma ocorrência já está no destino (copiada agora ou já existente).
+func (s *sourceDuplicateScan) MarkCopied(folder string, uid imap.UID) {
+	if s == nil {
+		return
+	}
+	c := sourceCopy{Folder: folder, UID: uid}
+	if _, ok := s.groupOf[c]; ok {
+		s.copied[c] = true
+	}
+}
+
+// MarkQueued regista que uma ocorrência foi selecionada para cópia na pasta em curso.
+func (s *sourceDuplicateScan) MarkQueued(folder string, uid imap.UID) {
+	if s == nil {
+		return
+	}
+	c := sourceCopy{Folder: folder, UID: uid}
+	if _, ok := s.groupOf[c]; ok {
+		s.queued[c] = true
+	}
+}
+
+// KeptIn devolve a pasta de uma ocorrência de maior prioridade da mesma mensagem que já está
+// no destino: copiada nesta execução (MarkCopied) ou numa anterior (checkpoint). Se nenhuma
+// estiver, por falha ou por ter sido filtrada, esta ocorrência é copiada em vez dela. Uma
+// ocorrência anterior na mesma pasta basta estar selecionada (MarkQueued): as duas vão para a
+// mesma pasta do destino e, se a cópia falhar, é repetida na execução seguinte.
+func (s *sourceDuplicateScan) KeptIn(folder string, uid imap.UID, checkpoint *CheckpointStore) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	c := sourceCopy{Folder: folder, UID: uid}
+	key, ok := s.groupOf[c]
+	if !ok {
+		return "", false
+	}
+	for _, other := range s.Groups[key] {
+		if other == c {
+			break
+		}
+		if s.copied[other] || (other.Folder == folder && s.queued[other]) ||
+			(checkpoint != nil && checkpoint.IsMigrated(other.Folder, other.UID)) {
+			return other.Folder, true
+		}
+	}
+	return "", false
+}
+
+// sourceDuplicateSummary conta as mensagens repetidas numa combinação de pastas da origem.
+type sourceDuplicateSummary struct {
+	Messages int
+	Folders  []string // por prioridade; uma só pasta = repetida dentro dela
+}
+
+// String descreve a combinação para os logs.
+func (d sourceDuplicateSummary) String() string {
+	line := fmt.Sprintf("%d mensagens: '%s' (prioritária)", d.Messages, d.Folders[0])
+	if len(d.Folders) > 1 {
+		return line + fmt.Sprintf(", também em '%s'", strings.Join(d.Folders[1:], "', '"))
+	}
+	return line + ", repetidas dentro da pasta"
+}
+
+// Report descreve a combinação para o relatório.
+func (d sourceDuplicateSummary) Report() string {
+	line := fmt.Sprintf("%d messages: '%s' (priority)", d.Messages, d.Folders[0])
+	if len(d.Folders) > 1 {
+		return line + fmt.Sprintf(", also '%s'", strings.Join(d.Folders[1:], "', '"))
+	}
+	return line + ", repeated within the folder"
+}
+
+// Summary agrupa os duplicados pelo conjunto de pastas onde aparecem: uma entrada por
+// combinação de pastas, com o número de mensagens e a pasta prioritária.
+func (s *sourceDuplicateScan) Summary() []sourceDuplicateSummary {
+	counts := make(map[string]*sourceDuplicateSummary)
+	for _, copies := range s.Groups {
+		folders := make([]string, 0, len(copies))
+		for _, c := range copies {
+			if !slices.Contains(folders, c.Folder) {
+				folders = append(folders, c.Folder)
+			}
+		}
+		combination := strings.Join(folders, "\x00")
+		if counts[combination] == nil {
+			counts[combination] = &sourceDuplicateSummary{Folders: folders}
+		}
+		counts[combination].Messages++
+	}
+
+	summary := make([]sourceDuplicateSummary, 0, len(counts))
+	for _, d := range counts {
+		summary = append(summary, *d)
+	}
+	slices.SortFunc(summary, func(a, b sourceDuplicateSummary) int {
+		return strings.Compare(a.Report(), b.Report())
+	})
+	return summary
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+func TestFolderPriority(t *testing.T) {
+	plan := []folderPlanEntry{{Source: "[Gmail]/All Mail"}, {Source: "INBOX"}, {Source: "Projetos/A"}, {Source: "Outros"}}
+	mailboxes := []*imap.ListData{{Mailbox: "[Gmail]/All Mail", Attrs: []imap.MailboxAttr{imap.MailboxAttrAll}}}
+	rules, err := compileFolderRules([]string{"Projetos/*"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ranks := folderPriority(plan, mailboxes, rules)
+	var order []string
+	for _, entry := range sortByPriority(plan, ranks) {
+		order = append(order, entry.Source)
+	}
+	want := []string{"Projetos/A", "INBOX", "Outros", "[Gmail]/All Mail"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("ordem = %q, esperado %q", order, want)
+		}
+	}
+	if plan[0].Source != "[Gmail]/All Mail" {
+		t.Error("sortByPriority alterou o plano original")
+	}
+}
+
+// Com source_duplicates "once", as outras ocorrências só são puladas quando a preferida já
+// está no destino; se esta falhar, a seguinte por prioridade é copiada.
+func TestSourceDuplicatesFallBackToNextCopy(t *testing.T) {
+	srv := newTestServer(t, nil, nil)
+	for _, folder := range []string{"Projetos", "Arquivo"} {
+		if err := srv.User.Create(folder, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg := testMessage("<x@test>", "repetida")
+	srv.addMessage(t, "INBOX", msg)
+	srv.addMessage(t, "Projetos", msg)
+	srv.addMessage(t, "Arquivo", msg)
+	srv.addMessage(t, "Arquivo", msg)
+	srv.addMessage(t, "Arquivo", testMessage("<y@test>", "única"))
+
+	client, err := connectClient(newEndpoint(srv.Host, "user", "pass", srv.options(), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	plan := []folderPlanEntry{{Source: "INBOX"}, {Source: "Projetos"}, {Source: "Arquivo"}}
+	ranks := map[string]int{"Projetos": 0, "INBOX": 1, "Arquivo": 2}
+	scan, err := scanSourceDuplicates(client, "origem@test", plan, ranks, DuplicateKeyMessageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scan.Messages != 5 || len(scan.Groups) != 1 {
+		t.Fatalf("análise: %d mensagens, %d grupos; esperado 5 e 1", scan.Messages, len(scan.Groups))
+	}
+
+	// Nada copiado ainda: nenhuma ocorrência é pulada
+	if kept, ok := scan.KeptIn("INBOX", 1, nil); ok {
+		t.Errorf("INBOX pulada antes de a cópia de Projetos existir (%s)", kept)
+	}
+
+	// A cópia de Projetos falhou: a INBOX é a seguinte e é copiada; o Arquivo já não
+	scan.MarkCopied("INBOX", 1)
+	if kept, ok := scan.KeptIn("Arquivo", 1, nil); !ok || kept != "INBOX" {
+		t.Errorf("KeptIn(Arquivo, 1) = %q, %v; esperado INBOX", kept, ok)
+	}
+
+	// Uma mensagem sem repetições nunca é pulada
+	if _, ok := scan.KeptIn("Arquivo", 3, nil); ok {
+		t.Error("mensagem única pulada")
+	}
+}
+
+func TestSourceDuplicatesSameFolderAndCheckpoint(t *testing.T) {
+	srv := newTestServer(t, nil, nil)
+	if err := srv.User.Create("Arquivo", nil); err != nil {
+		t.Fatal(err)
+	}
+	msg := testMessage("<x@test>", "repetida")
+	srv.addMessage(t, "INBOX", msg)
+	srv.addMessage(t, "Arquivo", msg)
+	srv.addMessage(t, "Arquivo", msg)
+
+	client, err := connectClient(newEndpoint(srv.Host, "user", "pass", srv.options(), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	plan := []folderPlanEntry{{Source: "INBOX"}, {Source: "Arquivo"}}
+	scan, err := scanSourceDuplicates(client, "origem@test", plan, map[string]int{"INBOX": 0, "Arquivo": 1}, DuplicateKeyMessageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A INBOX foi filtrada: a primeira do Arquivo é copiada e a segunda, na mesma pasta, pulada
+	if _, ok := scan.KeptIn("Arquivo", 1, nil); ok {
+		t.Error("Arquivo/1 pulado sem cópia no destino")
+	}
+	scan.MarkQueued("Arquivo", 1)
+	if kept, ok := scan.KeptIn("Arquivo", 2, nil); !ok || kept != "Arquivo" {
+		t.Errorf("KeptIn(Arquivo, 2) = %q, %v; esperado Arquivo", kept, ok)
+	}
+
+	// Numa execução seguinte, o checkpoint conta como cópia no destino
+	store, err := OpenCheckpointStore(t.TempDir(), "origem@test", "destino@test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err := store.BeginFolder("INBOX", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.MarkMigrated("INBOX", 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if kept, ok := scan.KeptIn("Arquivo", 1, store); !ok || kept != "INBOX" {
+		t.Errorf("KeptIn(Arquivo, 1) com checkpoint = %q, %v; esperado INBOX", kept, ok)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// Modos de execução da migração.
+const (
+	ModeCopy  = "copy"  // cópia completa (padrão)
+	ModeSync  = "sync"  // só mensagens novas e flags alteradas desde a última execução
+	ModeFlags = "flags" // só alinhar no destino as flags das mensagens já copiadas
+	ModePlan  = "plan"  // só mostrar o plano de pastas origem -> destino, sem copiar
+	ModeScan  = "scan"  // só analisar mensagens repetidas em várias pastas da origem, sem copiar
+)
+
+// flagSetKey normaliza um conjunto de flags para comparação e agrupamento.
+func flagSetKey(flags []imap.Flag) string {
+	names := make([]string, 0, len(flags))
+	for _, f := range filterValidFlags(flags) {
+		names = append(names, strings.ToLower(string(f)))
+	}
+	slices.Sort(names)
+	return strings.Join(names, " ")
+}
+
+// fetchFlagChanges devolve as flags atuais das mensagens já migradas (UIDs até highUID) que
+// mudaram na origem. Com CONDSTORE e um MODSEQ guardado, o servidor devolve só as alteradas;
+// caso contrário as flags de todas são comparadas com as registadas no checkpoint.
+func fetchFlagChanges(client *imapclient.Client, checkpoint *CheckpointStore, folder string, highUID imap.UID, sinceModSeq uint64) (map[imap.UID][]imap.Flag, error) {
+	uidSet := imap.UIDSet{}
+	uidSet.AddRange(1, highUID)
+
+	fetchOptions := &imap.FetchOptions{
+		UID:          true,
+		Flags:        true,
+		ChangedSince: sinceModSeq,
+	}
+
+	changes := make(map[imap.UID][]imap.Flag)
+	cmd := client.Fetch(uidSet, fetchOptions)
+	for {
+		msg := cmd.Next()
+		if msg == nil {
+			break
+		}
+		buf, err := msg.Collect()
+		if err != nil {
+			cmd.Close()
+			return nil, err
+		}
+
+		migrated, ok := checkpoint.Migrated(folder, buf.UID)
+		if !ok {
+			continue
+		}
+		if flagSetKey(buf.Flags) != flagSetKey(migrated.Flags) {
+			changes[buf.UID] = filterValidFlags(buf.Flags)
+		}
+	}
+	if err := cmd.Close(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// applyFlagChanges replica no destino (pasta já selecionada em modo leitura/escrita) as flags
+// alteradas na origem, usando o mapa de UIDs guardado no checkpoint. Mensagens sem UID de
+// destino conhecido, ou de uma UIDVALIDITY de destino anterior, são contadas em unmapped.
+func applyFlagChanges(client *imapclient.Client, checkpoint *CheckpointStore, folder string, destUIDValidity uint32, changes map[imap.UID][]imap.Flag) (updated, unmapped int, err error) {
+	// Agrupar por conjunto de flags para usar um único UID STORE por grupo
+	type group struct {
+		flags      []imap.Flag
+		sourceUIDs []imap.UID
+		destUIDs   imap.UIDSet
+	}
+	groups := make(map[string]*group)
+	for uid, flags := range changes {
+		migrated, ok := checkpoint.Migrated(folder, uid)
+		if !ok || migrated.DestUID == 0 || migrated.DestUIDValidity != destUIDValidity {
+			unmapped++
+			continue
+		}
+		key := flagSetKey(flags)
+		g := groups[key]
+		if g == nil {
+			g = &group{flags: flags}
+			groups[key] = g
+		}
+		g.sourceUIDs = append(g.sourceUIDs, uid)
+		g.destUIDs.AddNum(migrated.DestUID)
+	}
+
+	for _, g := range groups {
+		store := &imap.StoreFlags{
+			Op:     imap.StoreFlagsSet,
+			Silent: true,
+			Flags:  g.flags,
+		}
+		if err := client.Store(g.destUIDs, store, nil).Close(); err != nil {
+			return updated, unmapped, fmt.Errorf("erro ao atualizar flags no destino: %w", err)
+		}
+		for _, uid := range g.sourceUIDs {
+			if err := checkpoint.UpdateFlags(folder, uid, g.flags); err != nil {
+				return updated, unmapped, err
+			}
+		}
+		updated += len(g.sourceUIDs)
+	}
+	return updated, unmapped, checkpoint.Sync()
+}
+
+// fetchFlagsByUID obtém as flags das mensagens indicadas na pasta selecionada.
+func fetchFlagsByUID(client *imapclient.Client, uidSet imap.UIDSet) (map[imap.UID][]imap.Flag, error) {
+	flags := make(map[imap.UID][]imap.Flag)
+	cmd := client.Fetch(uidSet, &imap.FetchOptions{UID: true, Flags: true})
+	for {
+		msg := cmd.Next()
+		if msg == nil {
+			break
+		}
+		buf, err := msg.Collect()
+		if err != nil {
+			cmd.Close()
+			return nil, err
+		}
+		flags[buf.UID] = buf.Flags
+	}
+	if err := cmd.Close(); err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+// reconcileFlags compara as flags atuais de cada mensagem já copiada na origem com as flags
+// reais da cópia no destino, usando o mapa de UIDs obtido de APPENDUID. Devolve as mensagens
+// cujas flags no destino têm de ser alteradas. Ambas as pastas têm de estar selecionadas.
+func reconcileFlags(sourceClient, destClient *imapclient.Client, checkpoint *CheckpointStore, folder string, destUIDValidity uint32) (map[imap.UID][]imap.Flag, error) {
+	mapping := checkpoint.DestinationMap(folder, destUIDValidity)
+	if len(mapping) == 0 {
+		return nil, nil
+	}
+
+	var sourceSet, destSet imap.UIDSet
+	for sourceUID, destUID := range mapping {
+		sourceSet.AddNum(sourceUID)
+		destSet.AddNum(destUID)
+	}
+
+	sourceFlags, err := fetchFlagsByUID(sourceClient, sourceSet)
+	if err != nil {
+		return nil, &sourceSideError{Err: fmt.Errorf("erro ao obter flags na origem: %w", err)}
+	}
+	destFlags, err := fetchFlagsByUID(destClient, destSet)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao obter flags no destino: %w", err)
+	}
+
+	changes := make(map[imap.UID][]imap.Flag)
+	for sourceUID, destUID := range mapping {
+		current, ok := sourceFlags[sourceUID]
+		if !ok {
+			continue // apagada na origem
+		}
+		existing, ok := destFlags[destUID]
+		if !ok {
+			continue // apagada no destino
+		}
+		if flagSetKey(current) != flagSetKey(existing) {
+			changes[sourceUID] = filterValidFlags(current)
+		}
+	}
+	return changes, nil
+}
+
+// sourceSideError indica que a falha ocorreu na ligação à origem, para que seja essa a
+// ligação reestabelecida antes de repetir a operação.
+type sourceSideError struct {
+	Err error
+}
+
+func (e *sourceSideError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *sourceSideError) Unwrap() error {
+	return e.Err
+}
+
+// syncMigratedFlags seleciona a pasta de destino e alinha as flags das mensagens já copiadas
+// com as da origem (pasta já selecionada). Em dry-run apenas conta as que seriam alteradas.
+func syncMigratedFlags(sourceClient, destClient *imapclient.Client, checkpoint *CheckpointStore, folder, destFolder string, dryRun bool) (int, error) {
+	destData, err := destClient.Select(destFolder, &imap.SelectOptions{ReadOnly: dryRun}).Wait()
+	if err != nil {
+		return 0, fmt.Errorf("erro ao selecionar a pasta '%s' no destino: %w", destFolder, err)
+	}
+
+	changes, err := reconcileFlags(sourceClient, destClient, checkpoint, folder, destData.UIDValidity)
+	if err != nil || len(changes) == 0 {
+		return 0, err
+	}
+	if dryRun {
+		return len(changes), nil
+	}
+
+	updated, _, err := applyFlagChanges(destClient, checkpoint, folder, destData.UIDValidity, changes)
+	return updated, err
+}
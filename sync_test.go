@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// No modo flags, se a ligação à origem cair é a origem que é reconectada, e a pasta é
+// sincronizada na segunda tentativa.
+func TestFlagsModeReconnectsSource(t *testing.T) {
+	chdirTemp(t)
+
+	source := newTestServer(t, nil, nil)
+	dest := newTestServer(t, nil, nil)
+	source.addMessage(t, "INBOX", testMessage("<1@test>", "um"), imap.FlagSeen)
+	source.addMessage(t, "INBOX", testMessage("<2@test>", "dois"), imap.FlagFlagged)
+	source.addMessage(t, "INBOX", testMessage("<3@test>", "três"), imap.FlagAnswered)
+
+	acc := MigrationAccount{
+		SourceEmail: "origem@test", SourceUser: "user", SourcePass: "pass", SourceHost: source.Host,
+		DestinationEmail: "destino@test", DestinationUser: "user", DestinationPass: "pass", DestinationHost: dest.Host,
+		SourceConnection: source.options(), DestinationConnection: dest.options(),
+	}
+	stateDir := filepath.Join(t.TempDir(), "state")
+	for _, mode := range []string{ModeCopy, ModeFlags} {
+		if mode == ModeFlags {
+			clearDestinationFlags(t, dest)
+			source.dropNextFetches(1)
+		}
+		config := loadTestConfig(t, fmt.Sprintf(`{"mode": %q, "state_dir": %q}`, mode, stateDir))
+		group := groupAccountsByDestination([]MigrationAccount{acc}, config)[0]
+		if err := migrateAccount(acc, config, group); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+	}
+
+	// clearDestinationFlags já fez um STORE; o modo flags tem de fazer outro
+	stores := strings.Count(strings.Join(dest.Commands(), " "), "STORE")
+	if stores < 2 {
+		t.Errorf("o modo flags não alinhou as flags depois de a origem cair; comandos do destino: %v", dest.Commands())
+	}
+}
+
+// Numa execução retomada, as mensagens que faltam são numeradas entre as que restam processar,
+// e não pela posição na pasta.
+func TestResumedRunNumbersRemainingMessages(t *testing.T) {
+	chdirTemp(t)
+
+	source := newTestServer(t, nil, nil)
+	dest := newTestServer(t, nil, nil)
+	source.addMessage(t, "INBOX", testMessage("<1@test>", "um"))
+	source.addMessage(t, "INBOX", testMessage("<2@test>", "dois"))
+
+	acc := MigrationAccount{
+		SourceEmail: "origem@test", SourceUser: "user", SourcePass: "pass", SourceHost: source.Host,
+		DestinationEmail: "destino@test", DestinationUser: "user", DestinationPass: "pass", DestinationHost: dest.Host,
+		SourceConnection: source.options(), DestinationConnection: dest.options(),
+	}
+	config := loadTestConfig(t, fmt.Sprintf(`{"state_dir": %q}`, filepath.Join(t.TempDir(), "state")))
+	group := groupAccountsByDestination([]MigrationAccount{acc}, config)[0]
+	if err := migrateAccount(acc, config, group); err != nil {
+		t.Fatal(err)
+	}
+	source.addMessage(t, "INBOX", testMessage("<3@test>", "três"))
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	if err := migrateAccount(acc, config, group); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "Mensagem 1/1 copiada") {
+		t.Errorf("log sem \"Mensagem 1/1 copiada\":\n%s", logs.String())
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapserver"
+	"github.com/emersion/go-imap/v2/imapserver/imapmemserver"
+)
+
+// testServer é um servidor IMAP em memória para os testes. Regista os comandos que alteram
+// o estado da caixa (e o modo de cada SELECT), tal como chegam à sessão do servidor.
+type testServer struct {
+	Host string
+	Port int
+	User *imapmemserver.User
+
+	mu          sync.Mutex
+	commands    []string
+	failAppends int // os próximos APPEND a recusar
+	dropFetches int // os próximos FETCH em que a ligação é cortada
+}
+
+// newTestServer arranca um servidor com o utilizador "user"/"pass" e a pasta INBOX. Com
+// tlsConfig, o servidor anuncia STARTTLS.
+func newTestServer(t *testing.T, caps imap.CapSet, tlsConfig *tls.Config) *testServer {
+	t.Helper()
+
+	user := imapmemserver.NewUser("user", "pass")
+	if err := user.Create("INBOX", nil); err != nil {
+		t.Fatal(err)
+	}
+	srv := &testServer{Host: "127.0.0.1", User: user}
+
+	if caps == nil {
+		caps = imap.CapSet{imap.CapIMAP4rev1: {}}
+	}
+	server := imapserver.New(&imapserver.Options{
+		NewSession: func(conn *imapserver.Conn) (imapserver.Session, *imapserver.GreetingData, error) {
+			return &recordingSession{UserSession: imapmemserver.NewUserSession(user), srv: srv, conn: conn}, nil, nil
+		},
+		Caps:         caps,
+		TLSConfig:    tlsConfig,
+		InsecureAuth: true,
+		Logger:       log.New(io.Discard, "", 0),
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+
+	srv.Port = ln.Addr().(*net.TCPAddr).Port
+	return srv
+}
+
+// options devolve as opções de ligação sem TLS para este servidor.
+func (s *testServer) options() ConnectionOptions {
+	return ConnectionOptions{Port: s.Port, Security: SecurityPlain}
+}
+
+// addMessage guarda uma mensagem diretamente numa pasta, sem passar pela sessão.
+func (s *testServer) addMessage(t *testing.T, folder, raw string, flags ...imap.Flag) {
+	t.Helper()
+	if _, err := s.User.Append(folder, bytes.NewReader([]byte(raw)), &imap.AppendOptions{Flags: flags}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// record regista um comando recebido.
+func (s *testServer) record(command string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands = append(s.commands, command)
+}
+
+// failNextAppends faz o servidor recusar os próximos n APPEND.
+func (s *testServer) failNextAppends(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failAppends = n
+}
+
+// dropNextFetches faz o servidor cortar a ligação nos próximos n FETCH.
+func (s *testServer) dropNextFetches(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropFetches = n
+}
+
+// Commands devolve os comandos registados até agora.
+func (s *testServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// recordingSession é uma sessão do servidor em memória que regista os comandos com efeitos.
+type recordingSession struct {
+	*imapmemserver.UserSession
+	srv  *testServer
+	conn *imapserver.Conn
+}
+
+func (s *recordingSession) Select(mailbox string, options *imap.SelectOptions) (*imap.SelectData, error) {
+	if options != nil && options.ReadOnly {
+		s.srv.record("EXAMINE")
+	} else {
+		s.srv.record("SELECT")
+	}
+	return s.UserSession.Select(mailbox, options)
+}
+
+func (s *recordingSession) Create(mailbox string, options *imap.CreateOptions) error {
+	s.srv.record("CREATE")
+	return s.UserSession.Create(mailbox, options)
+}
+
+func (s *recordingSession) Delete(mailbox string) error {
+	s.srv.record("DELETE")
+	return s.UserSession.Delete(mailbox)
+}
+
+func (s *recordingSession) Rename(mailbox, newName string, options *imap.RenameOptions) error {
+	s.srv.record("RENAME")
+	return s.UserSession.Rename(mailbox, newName, options)
+}
+
+func (s *recordingSession) Subscribe(mailbox string) error {
+	s.srv.record("SUBSCRIBE")
+	return s.UserSession.Subscribe(mailbox)
+}
+
+func (s *recordingSession) Unsubscribe(mailbox string) error {
+	s.srv.record("UNSUBSCRIBE")
+	return s.UserSession.Unsubscribe(mailbox)
+}
+
+func (s *recordingSession) Append(mailbox string, r imap.LiteralReader, options *imap.AppendOptions) (*imap.AppendData, error) {
+	s.srv.record("APPEND")
+	s.srv.mu.Lock()
+	fail := s.srv.failAppends > 0
+	if fail {
+		s.srv.failAppends--
+	}
+	s.srv.mu.Unlock()
+	if fail {
+		io.Copy(io.Discard, r)
+		return nil, &imap.Error{Type: imap.StatusResponseTypeNo, Text: "APPEND recusado pelo teste"}
+	}
+	return s.UserSession.Append(mailbox, r, options)
+}
+
+func (s *recordingSession) Expunge(w *imapserver.ExpungeWriter, uids *imap.UIDSet) error {
+	s.srv.record("EXPUNGE")
+	return s.UserSession.Expunge(w, uids)
+}
+
+func (s *recordingSession) Store(w *imapserver.FetchWriter, numSet imap.NumSet, flags *imap.StoreFlags, options *imap.StoreOptions) error {
+	s.srv.record("STORE")
+	return s.UserSession.Store(w, numSet, flags, options)
+}
+
+func (s *recordingSession) Copy(numSet imap.NumSet, dest string) (*imap.CopyData, error) {
+	s.srv.record("COPY")
+	return s.UserSession.Copy(numSet, dest)
+}
+
+func (s *recordingSession) Move(w *imapserver.MoveWriter, numSet imap.NumSet, dest string) error {
+	s.srv.record("MOVE")
+	return s.UserSession.Move(w, numSet, dest)
+}
+
+// Fetch regista os FETCH que marcariam as mensagens como lidas (BODY[] sem PEEK) e corta a
+// ligação se o teste o pediu.
+func (s *recordingSession) Fetch(w *imapserver.FetchWriter, numSet imap.NumSet, options *imap.FetchOptions) error {
+	s.srv.mu.Lock()
+	drop := s.srv.dropFetches > 0
+	if drop {
+		s.srv.dropFetches--
+	}
+	s.srv.mu.Unlock()
+	if drop {
+		s.conn.NetConn().Close()
+		return &imap.Error{Type: imap.StatusResponseTypeBye, Text: "ligação cortada pelo teste"}
+	}
+	for _, section := range options.BodySection {
+		if !section.Peek {
+			s.srv.record("FETCH BODY[]")
+		}
+	}
+	return s.UserSession.Fetch(w, numSet, options)
+}